@@ -0,0 +1,80 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"github.com/pluralsh/test-harness/pkg/plural"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestRunSpec identifies a single execution of a TestSuite
+type TestRunSpec struct {
+	// the name of the suite this run belongs to
+	TestSuite string `json:"testSuite"`
+
+	// the name of the argo workflow executing this run
+	WorkflowName string `json:"workflowName"`
+
+	// the id of the plural test for this run
+	PluralId string `json:"pluralId,omitempty"`
+}
+
+// TestRunStatus is a snapshot of the state of a single execution of a TestSuite
+type TestRunStatus struct {
+	// the status of the entire run
+	Status plural.Status `json:"testStatus,omitempty"`
+
+	// a machine readable reason for the status of the run, eg TimedOut
+	Reason string `json:"reason,omitempty"`
+
+	// the status for each individual step, including the pods their logs can be found in
+	Steps []*StepStatus `json:"stepStatus,omitempty"`
+
+	// time when the run started
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// time when the run was completed
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Suite",type="string",JSONPath=".spec.testSuite"
+//+kubebuilder:printcolumn:name="Status",type="string",JSONPath=".status.testStatus"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// TestRun is the Schema for the testruns API
+type TestRun struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TestRunSpec   `json:"spec,omitempty"`
+	Status TestRunStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// TestRunList contains a list of TestRun
+type TestRunList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TestRun `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TestRun{}, &TestRunList{})
+}
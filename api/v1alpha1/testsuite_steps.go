@@ -0,0 +1,68 @@
+package v1alpha1
+
+import "fmt"
+
+const (
+	unvisited = iota
+	visiting
+	visited
+)
+
+// StepDependencies returns the names of the steps each step depends on.  If no step declares dependsOn
+// the steps are chained sequentially in declaration order.  Unknown step names and cycles are rejected.
+func (spec *TestSuiteSpec) StepDependencies() (map[string][]string, error) {
+	deps := map[string][]string{}
+	explicit := false
+	for _, step := range spec.Steps {
+		if _, ok := deps[step.Name]; ok {
+			return nil, fmt.Errorf("duplicate step name %s", step.Name)
+		}
+		deps[step.Name] = step.DependsOn
+		explicit = explicit || len(step.DependsOn) > 0
+	}
+
+	if !explicit {
+		for i, step := range spec.Steps {
+			if i > 0 {
+				deps[step.Name] = []string{spec.Steps[i-1].Name}
+			}
+		}
+		return deps, nil
+	}
+
+	for _, step := range spec.Steps {
+		for _, dep := range step.DependsOn {
+			if _, ok := deps[dep]; !ok {
+				return nil, fmt.Errorf("step %s depends on unknown step %s", step.Name, dep)
+			}
+		}
+	}
+
+	state := map[string]int{}
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("dependency cycle detected: %v", append(path, name))
+		case visited:
+			return nil
+		}
+
+		state[name] = visiting
+		for _, dep := range deps[name] {
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+
+	for _, step := range spec.Steps {
+		if err := visit(step.Name, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return deps, nil
+}
@@ -0,0 +1,75 @@
+package v1alpha1
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStepDependencies(t *testing.T) {
+	step := func(name string, deps ...string) *TestStep {
+		return &TestStep{Name: name, DependsOn: deps}
+	}
+
+	cases := []struct {
+		name     string
+		steps    []*TestStep
+		expected map[string][]string
+		err      string
+	}{
+		{
+			name:     "chains steps sequentially without dependsOn",
+			steps:    []*TestStep{step("a"), step("b"), step("c")},
+			expected: map[string][]string{"a": nil, "b": {"a"}, "c": {"b"}},
+		},
+		{
+			name:     "uses explicit edges",
+			steps:    []*TestStep{step("a"), step("b"), step("c", "a", "b")},
+			expected: map[string][]string{"a": nil, "b": nil, "c": {"a", "b"}},
+		},
+		{
+			name:     "allows a diamond",
+			steps:    []*TestStep{step("a"), step("b", "a"), step("c", "a"), step("d", "b", "c")},
+			expected: map[string][]string{"a": nil, "b": {"a"}, "c": {"a"}, "d": {"b", "c"}},
+		},
+		{
+			name:  "rejects duplicate names",
+			steps: []*TestStep{step("a"), step("a")},
+			err:   "duplicate step name a",
+		},
+		{
+			name:  "rejects unknown dependencies",
+			steps: []*TestStep{step("a"), step("b", "missing")},
+			err:   "step b depends on unknown step missing",
+		},
+		{
+			name:  "rejects self dependencies",
+			steps: []*TestStep{step("a", "a")},
+			err:   "dependency cycle detected",
+		},
+		{
+			name:  "rejects cycles",
+			steps: []*TestStep{step("a", "c"), step("b", "a"), step("c", "b")},
+			err:   "dependency cycle detected",
+		},
+	}
+
+	for _, c := range cases {
+		spec := &TestSuiteSpec{Steps: c.steps}
+		deps, err := spec.StepDependencies()
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: expected error containing %q, got %v", c.name, c.err, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(deps, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, deps)
+		}
+	}
+}
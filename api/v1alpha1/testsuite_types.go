@@ -22,6 +22,37 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// +kubebuilder:validation:Enum=Failed;Error;Always
+type RetryOn string
+
+const (
+	RetryOnFailed RetryOn = "Failed"
+	RetryOnError  RetryOn = "Error"
+	RetryOnAlways RetryOn = "Always"
+)
+
+type RetryBackoff struct {
+	// the initial delay between attempts, either seconds or a duration string (eg 30s, 2m)
+	Duration string `json:"duration,omitempty"`
+
+	// the multiplier applied to the delay after each failed attempt
+	Factor *int32 `json:"factor,omitempty"`
+
+	// the maximum amount of time to keep retrying for
+	MaxDuration string `json:"maxDuration,omitempty"`
+}
+
+type RetryPolicy struct {
+	// the maximum number of retries, not including the first attempt
+	Limit int32 `json:"limit"`
+
+	// which failures should be retried, defaults to Failed
+	RetryOn RetryOn `json:"retryOn,omitempty"`
+
+	// the backoff between attempts
+	Backoff *RetryBackoff `json:"backoff,omitempty"`
+}
+
 type TestStep struct {
 	// the name for this step
 	Name string `json:"name"`
@@ -31,6 +62,31 @@ type TestStep struct {
 
 	// the argo template to use for this step
 	Template *argov1alpha1.Template `json:"template"`
+
+	// names of steps that must complete before this one starts.  If no step in the suite sets this,
+	// steps run sequentially in the order they're declared
+	DependsOn []string `json:"dependsOn,omitempty"`
+
+	// how to retry this step if it fails
+	Retry *RetryPolicy `json:"retry,omitempty"`
+
+	// the maximum time this step may run for, including all retries
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// which of the step's containers to capture logs from, defaults to just the main container
+	Logs *LogSelection `json:"logs,omitempty"`
+}
+
+// LogSelection picks the containers a step's logs are captured from
+type LogSelection struct {
+	// also capture init containers, eg argo's artifact loading
+	InitContainers bool `json:"initContainers,omitempty"`
+
+	// names of the containers to capture, defaults to main.  Use * to capture every container
+	Include []string `json:"include,omitempty"`
+
+	// names of containers to leave out, eg argo's wait container when capturing everything
+	Exclude []string `json:"exclude,omitempty"`
 }
 
 // TestSuiteSpec defines the desired state of TestSuite
@@ -46,8 +102,52 @@ type TestSuiteSpec struct {
 
 	// test steps to run
 	Steps []*TestStep `json:"steps,omitempty"`
+
+	// the maximum time the entire suite may run for
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// whether to rerun the suite whenever its spec changes
+	RerunOnChange bool `json:"rerunOnChange,omitempty"`
+
+	// the number of previous runs to keep, defaults to 10
+	HistoryLimit *int32 `json:"historyLimit,omitempty"`
+
+	// how long to keep the suite after it finishes, defaults to the controller's --suite-ttl
+	TTLSecondsAfterFinished *int32 `json:"ttlSecondsAfterFinished,omitempty"`
+
+	// overrides ttlSecondsAfterFinished for suites that succeed
+	TTLSecondsAfterSuccess *int32 `json:"ttlSecondsAfterSuccess,omitempty"`
+
+	// overrides ttlSecondsAfterFinished for suites that fail or are cancelled, eg to keep them longer for debugging
+	TTLSecondsAfterFailure *int32 `json:"ttlSecondsAfterFailure,omitempty"`
+
+	// pauses the running workflow until unset
+	Suspend bool `json:"suspend,omitempty"`
+
+	// cancels the current run, either letting running steps finish (Stop) or killing them (Terminate).
+	// This must be cleared before the suite is rerun
+	Cancel CancelStrategy `json:"cancel,omitempty"`
 }
 
+// +kubebuilder:validation:Enum=Stop;Terminate
+type CancelStrategy string
+
+const (
+	CancelStop      CancelStrategy = "Stop"
+	CancelTerminate CancelStrategy = "Terminate"
+)
+
+const (
+	// ReasonTimedOut marks a failed step or suite that exceeded its timeout
+	ReasonTimedOut = "TimedOut"
+
+	// ReasonCancelled marks a step or suite that was cancelled through spec.cancel
+	ReasonCancelled = "Cancelled"
+
+	// ReasonInvalidSpec marks a suite whose spec failed validation, so it can't be run
+	ReasonInvalidSpec = "InvalidSpec"
+)
+
 type StepStatus struct {
 	// the id for this test step
 	PluralId string `json:"pluralId"`
@@ -57,6 +157,36 @@ type StepStatus struct {
 
 	// the status of this test step
 	Status plural.Status `json:"status"`
+
+	// the number of times this step has been attempted
+	Attempts int32 `json:"attempts,omitempty"`
+
+	// a machine readable reason for the current status, eg TimedOut
+	Reason string `json:"reason,omitempty"`
+
+	// the pods this step has run in, one per attempt
+	Pods []string `json:"pods,omitempty"`
+}
+
+// RunStatus is an archived summary of a previous execution of a suite
+type RunStatus struct {
+	// the id of the plural test for this run
+	PluralId string `json:"pluralId"`
+
+	// the name of the argo workflow for this run
+	WorkflowName string `json:"workflowName"`
+
+	// the final status of this run
+	Status plural.Status `json:"testStatus"`
+
+	// a machine readable reason for the status of this run
+	Reason string `json:"reason,omitempty"`
+
+	// the status for each individual step of this run
+	Steps []*StepStatus `json:"stepStatus,omitempty"`
+
+	// time when this run was completed
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
 }
 
 // TestSuiteStatus defines the observed state of TestSuite
@@ -70,13 +200,59 @@ type TestSuiteStatus struct {
 	// the status for each individual step
 	Steps []*StepStatus `json:"stepStatus"`
 
-	// the name of the associated argo workflow
+	// the name of the run executing the suite, eg its argo workflow
 	WorkflowName string `json:"workflowName"`
 
+	// time when the current run started executing
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
 	// time when the suite was completed
 	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// a machine readable reason for the status of the entire test, eg TimedOut
+	Reason string `json:"reason,omitempty"`
+
+	// the most recent generation of the suite observed by the controller
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// standard conditions describing the progress of the suite
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// the value of the rerun annotation handled by the current run
+	RerunToken string `json:"rerunToken,omitempty"`
+
+	// the number of times this suite has been run, including the current run
+	Runs int32 `json:"runs,omitempty"`
+
+	// previous runs of this suite, most recent first
+	History []*RunStatus `json:"history,omitempty"`
 }
 
+const (
+	// ConditionReady is false if the suite's spec is invalid and it can't be run
+	ConditionReady = "Ready"
+
+	// ConditionWorkflowCreated is true once the run executing the suite has been started
+	ConditionWorkflowCreated = "WorkflowCreated"
+
+	// ConditionPluralRegistered is true once the test has been registered with plural
+	ConditionPluralRegistered = "PluralRegistered"
+
+	// ConditionPluralSynced is false if the latest status of the suite couldn't be pushed to plural
+	ConditionPluralSynced = "PluralSynced"
+
+	// ConditionLogsStreaming is true while step logs are being tailed to plural
+	ConditionLogsStreaming = "LogsStreaming"
+
+	// ConditionCompleted is true once the suite has finished, regardless of outcome
+	ConditionCompleted = "Completed"
+
+	// ConditionSucceeded is true if the suite succeeded and false if it failed
+	ConditionSucceeded = "Succeeded"
+)
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 
@@ -0,0 +1,181 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"time"
+
+	argov1alpha1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// EntrypointName is the name of the generated dag template driving a suite's workflow, so no step may use it
+const EntrypointName = "plrl-entrypoint"
+
+// DefaultTimeout is applied to suites that don't set spec.timeout
+var DefaultTimeout = metav1.Duration{Duration: 2 * time.Hour}
+
+var testsuitelog = logf.Log.WithName("testsuite-resource")
+
+func (r *TestSuite) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-test-plural-sh-v1alpha1-testsuite,mutating=true,failurePolicy=fail,sideEffects=None,groups=test.plural.sh,resources=testsuites,verbs=create,versions=v1alpha1,name=mtestsuite.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Defaulter = &TestSuite{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type.  It's only called on create,
+// so clearing a default later on sticks
+func (r *TestSuite) Default() {
+	testsuitelog.Info("default", "name", r.Name)
+
+	if r.Spec.Timeout == nil {
+		timeout := DefaultTimeout
+		r.Spec.Timeout = &timeout
+	}
+
+	for _, step := range r.Spec.Steps {
+		if step != nil && step.Description == "" {
+			step.Description = step.Name
+		}
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-test-plural-sh-v1alpha1-testsuite,mutating=false,failurePolicy=fail,sideEffects=None,groups=test.plural.sh,resources=testsuites,verbs=create;update,versions=v1alpha1,name=vtestsuite.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &TestSuite{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *TestSuite) ValidateCreate() error {
+	testsuitelog.Info("validate create", "name", r.Name)
+	return r.ValidateSpec()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type.  Only spec changes are
+// validated, so suites created before a rule existed can still have their finalizer and metadata updated.
+func (r *TestSuite) ValidateUpdate(old runtime.Object) error {
+	testsuitelog.Info("validate update", "name", r.Name)
+	if !r.DeletionTimestamp.IsZero() {
+		return nil
+	}
+
+	if oldSuite, ok := old.(*TestSuite); ok && equality.Semantic.DeepEqual(oldSuite.Spec, r.Spec) {
+		return nil
+	}
+
+	return r.ValidateSpec()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *TestSuite) ValidateDelete() error {
+	return nil
+}
+
+// ValidateSpec checks the suite can be converted into a workflow.  The controller runs it as well, since the
+// webhook is optional.
+func (r *TestSuite) ValidateSpec() error {
+	var errs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if r.Spec.Repository == "" {
+		errs = append(errs, field.Required(specPath.Child("repository"), "the repository the test runs in must be set"))
+	}
+
+	if r.Spec.Timeout != nil && r.Spec.Timeout.Duration <= 0 {
+		errs = append(errs, field.Invalid(specPath.Child("timeout"), r.Spec.Timeout.Duration.String(), "must be positive"))
+	}
+
+	stepsPath := specPath.Child("steps")
+	if len(r.Spec.Steps) == 0 {
+		errs = append(errs, field.Required(stepsPath, "at least one step is required"))
+	}
+
+	names := map[string]bool{}
+	for i, step := range r.Spec.Steps {
+		path := stepsPath.Index(i)
+		if step == nil {
+			errs = append(errs, field.Required(path, "steps cannot be empty"))
+			continue
+		}
+
+		switch {
+		case step.Name == "":
+			errs = append(errs, field.Required(path.Child("name"), "every step needs a name"))
+		case step.Name == EntrypointName:
+			errs = append(errs, field.Invalid(path.Child("name"), step.Name, "this name is reserved for the generated workflow entrypoint"))
+		case names[step.Name]:
+			errs = append(errs, field.Duplicate(path.Child("name"), step.Name))
+		}
+		names[step.Name] = true
+
+		if step.Template == nil {
+			errs = append(errs, field.Required(path.Child("template"), "every step needs an argo template"))
+		} else if step.Template.GetType() == argov1alpha1.TemplateTypeUnknown || templateTypes(step.Template) > 1 {
+			errs = append(errs, field.Invalid(path.Child("template"), step.Name, "templates must set exactly one of container, containerSet, script, resource, data, http, plugin, suspend, dag or steps"))
+		}
+
+		if step.Timeout != nil && step.Timeout.Duration <= 0 {
+			errs = append(errs, field.Invalid(path.Child("timeout"), step.Timeout.Duration.String(), "must be positive"))
+		}
+
+		if step.Retry != nil && step.Retry.Limit < 0 {
+			errs = append(errs, field.Invalid(path.Child("retry", "limit"), step.Retry.Limit, "cannot be negative"))
+		}
+	}
+
+	if len(errs) == 0 {
+		if _, err := r.Spec.StepDependencies(); err != nil {
+			errs = append(errs, field.Invalid(stepsPath, "dependsOn", err.Error()))
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return apierrors.NewInvalid(GroupVersion.WithKind("TestSuite").GroupKind(), r.Name, errs)
+}
+
+func templateTypes(tmpl *argov1alpha1.Template) (count int) {
+	for _, set := range []bool{
+		tmpl.Container != nil,
+		tmpl.ContainerSet != nil,
+		tmpl.Script != nil,
+		tmpl.Resource != nil,
+		tmpl.Data != nil,
+		tmpl.HTTP != nil,
+		tmpl.Plugin != nil,
+		tmpl.Suspend != nil,
+		tmpl.DAG != nil,
+		tmpl.Steps != nil,
+	} {
+		if set {
+			count++
+		}
+	}
+	return
+}
@@ -0,0 +1,35 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateUpdate(t *testing.T) {
+	// predates the repository and reserved name rules
+	legacy := &TestSuite{
+		ObjectMeta: metav1.ObjectMeta{Name: "legacy"},
+		Spec:       TestSuiteSpec{Steps: []*TestStep{{Name: EntrypointName}}},
+	}
+
+	finalized := legacy.DeepCopy()
+	finalized.Finalizers = []string{"test.plural.sh/cleanup"}
+	if err := finalized.ValidateUpdate(legacy); err != nil {
+		t.Errorf("metadata only updates shouldn't be validated: %v", err)
+	}
+
+	deleting := legacy.DeepCopy()
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+	deleting.Spec.Tags = []string{"changed"}
+	if err := deleting.ValidateUpdate(legacy); err != nil {
+		t.Errorf("deleting suites shouldn't be validated: %v", err)
+	}
+
+	changed := legacy.DeepCopy()
+	changed.Spec.Tags = []string{"changed"}
+	if err := changed.ValidateUpdate(legacy); err == nil {
+		t.Errorf("expected spec changes to be validated")
+	}
+}
@@ -0,0 +1,334 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	argov1alpha1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
+	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
+	"github.com/pluralsh/test-harness/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// ArgoExecutor runs each suite as an argo workflow with a dag task per step
+type ArgoExecutor struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+func (e *ArgoExecutor) Object() client.Object {
+	return &argov1alpha1.Workflow{}
+}
+
+func (e *ArgoExecutor) Validate(suite *testv1alpha1.TestSuite) error {
+	_, err := suiteToWorkflow(suite)
+	return err
+}
+
+func (e *ArgoExecutor) Create(ctx context.Context, suite *testv1alpha1.TestSuite) (string, error) {
+	wf, err := suiteToWorkflow(suite)
+	if err != nil {
+		return "", err
+	}
+
+	if err := controllerutil.SetControllerReference(suite, &wf, e.Scheme); err != nil {
+		return "", err
+	}
+
+	if err := e.createServiceAccount(ctx, wf.Namespace, serviceAccountName); err != nil {
+		return "", err
+	}
+
+	if err := e.addMinimalRole(ctx, wf.Namespace, serviceAccountName); err != nil {
+		return "", err
+	}
+
+	existing, err := e.findWorkflow(ctx, suite)
+	if err != nil {
+		return "", err
+	}
+
+	if existing != nil {
+		return existing.Name, nil
+	}
+
+	if err := e.Client.Create(ctx, &wf); err != nil {
+		return "", err
+	}
+
+	return wf.Name, nil
+}
+
+func (e *ArgoExecutor) Sync(ctx context.Context, suite *testv1alpha1.TestSuite) error {
+	wf, err := e.workflow(ctx, suite)
+	if err != nil {
+		return err
+	}
+
+	syncWorkflowStatus(wf, suite)
+	return nil
+}
+
+func (e *ArgoExecutor) Suspend(ctx context.Context, suite *testv1alpha1.TestSuite) error {
+	wf, err := e.workflow(ctx, suite)
+	if err != nil {
+		return err
+	}
+
+	suspended := wf.Spec.Suspend != nil && *wf.Spec.Suspend
+	if suspended == suite.Spec.Suspend || wf.Status.Phase.Completed() {
+		return nil
+	}
+
+	wf.Spec.Suspend = &suite.Spec.Suspend
+	return e.Update(ctx, wf)
+}
+
+func (e *ArgoExecutor) Cancel(ctx context.Context, suite *testv1alpha1.TestSuite) error {
+	wf, err := e.workflow(ctx, suite)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	if wf.Status.Phase.Completed() || wf.Spec.Shutdown != argov1alpha1.ShutdownStrategyNone {
+		return nil
+	}
+
+	wf.Spec.Shutdown = argov1alpha1.ShutdownStrategy(suite.Spec.Cancel)
+	return e.Update(ctx, wf)
+}
+
+func (e *ArgoExecutor) Pods(ctx context.Context, suite *testv1alpha1.TestSuite) (map[string][]*corev1.Pod, error) {
+	wf, err := e.workflow(ctx, suite)
+	if err != nil {
+		return nil, err
+	}
+
+	var pods corev1.PodList
+	if err := e.List(ctx, &pods, client.InNamespace(suite.Namespace), client.MatchingLabels{workflowLabel: wf.Name}); err != nil {
+		return nil, err
+	}
+
+	res := map[string][]*corev1.Pod{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !podStarted(pod) {
+			continue
+		}
+
+		if node, ok := wf.Status.Nodes[pod.Annotations[nodeIDAnnotation]]; ok {
+			res[node.TemplateName] = append(res[node.TemplateName], pod)
+		}
+	}
+
+	return res, nil
+}
+
+func (e *ArgoExecutor) workflow(ctx context.Context, suite *testv1alpha1.TestSuite) (*argov1alpha1.Workflow, error) {
+	var wf argov1alpha1.Workflow
+	if err := e.Get(ctx, types.NamespacedName{Namespace: suite.Namespace, Name: suite.Status.WorkflowName}, &wf); err != nil {
+		return nil, err
+	}
+	return &wf, nil
+}
+
+// findWorkflow looks for a workflow already created for the suite's current run
+func (e *ArgoExecutor) findWorkflow(ctx context.Context, suite *testv1alpha1.TestSuite) (*argov1alpha1.Workflow, error) {
+	var wf argov1alpha1.Workflow
+	err := e.Get(ctx, types.NamespacedName{Namespace: suite.Namespace, Name: workflowName(suite)}, &wf)
+	if err == nil && metav1.IsControlledBy(&wf, suite) {
+		return &wf, nil
+	}
+
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	var wfs argov1alpha1.WorkflowList
+	if err := e.List(ctx, &wfs, client.InNamespace(suite.Namespace), runLabels(suite)); err != nil {
+		return nil, err
+	}
+
+	for i := range wfs.Items {
+		if metav1.IsControlledBy(&wfs.Items[i], suite) {
+			return &wfs.Items[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (e *ArgoExecutor) createServiceAccount(ctx context.Context, namespace, sa string) error {
+	var serviceaccount corev1.ServiceAccount
+	if err := e.Get(ctx, types.NamespacedName{Name: sa, Namespace: namespace}, &serviceaccount); err != nil {
+		serviceaccount.Name = sa
+		serviceaccount.Namespace = namespace
+		serviceaccount.Labels = map[string]string{managedLabel: "true"}
+		return e.Client.Create(ctx, &serviceaccount)
+	}
+	return nil
+}
+
+func (e *ArgoExecutor) addMinimalRole(ctx context.Context, namespace, sa string) error {
+	var crb rbacv1.ClusterRoleBinding
+	name := minimalRoleName(namespace, sa)
+	if err := e.Get(ctx, types.NamespacedName{Name: name}, &crb); err != nil {
+		crb.Name = name
+		crb.Labels = map[string]string{managedLabel: "true"}
+		crb.Subjects = []rbacv1.Subject{{Kind: "ServiceAccount", APIGroup: "", Name: sa, Namespace: namespace}}
+		crb.RoleRef = rbacv1.RoleRef{
+			APIGroup: "rbac.authorization.k8s.io",
+			Kind:     "ClusterRole",
+			Name:     "argo-workflow-minimal-role",
+		}
+
+		return e.Client.Create(ctx, &crb)
+	}
+	return nil
+}
+
+func minimalRoleName(namespace, sa string) string {
+	return fmt.Sprintf("%s-%s-argo-minimal-role", namespace, sa)
+}
+
+func syncWorkflowStatus(wf *argov1alpha1.Workflow, suite *testv1alpha1.TestSuite) {
+	suite.Status.Status = toPluralStatus(string(wf.Status.Phase))
+	if workflowTimedOut(wf, suite) {
+		suite.Status.Reason = testv1alpha1.ReasonTimedOut
+	}
+	if suite.Status.StartTime == nil && !wf.Status.StartedAt.IsZero() {
+		suite.Status.StartTime = wf.Status.StartedAt.DeepCopy()
+	}
+	statuses := stepStatuses(suite)
+	steps := map[string]*testv1alpha1.TestStep{}
+	for _, step := range suite.Spec.Steps {
+		steps[step.Name] = step
+	}
+	podNodes := make([]argov1alpha1.NodeStatus, 0)
+	for _, nodeStatus := range wf.Status.Nodes {
+		status, ok := statuses[nodeStatus.TemplateName]
+		if !ok {
+			continue
+		}
+
+		// steps with a retry strategy get a retry node holding the overall phase, with a pod node per attempt
+		step := steps[nodeStatus.TemplateName]
+		switch nodeStatus.Type {
+		case argov1alpha1.NodeTypeRetry:
+			syncNodeStatus(nodeStatus, firstAttempt(wf, nodeStatus).StartedAt, step, status)
+		case argov1alpha1.NodeTypePod:
+			podNodes = append(podNodes, nodeStatus)
+			if !hasRetryNode(wf, nodeStatus.TemplateName) {
+				syncNodeStatus(nodeStatus, nodeStatus.StartedAt, step, status)
+			}
+		}
+	}
+
+	sort.SliceStable(podNodes, func(i, j int) bool {
+		return podNodes[i].StartedAt.Before(&podNodes[j].StartedAt)
+	})
+	pods := map[string][]string{}
+	for _, nodeStatus := range podNodes {
+		pods[nodeStatus.TemplateName] = append(pods[nodeStatus.TemplateName], workflowPodName(wf, nodeStatus))
+	}
+
+	for name, names := range pods {
+		statuses[name].Pods = names
+		statuses[name].Attempts = int32(len(names))
+	}
+
+	syncCompletionTime(suite)
+}
+
+// syncNodeStatus takes the step's phase from its node, and whether it timed out from the time between its first
+// attempt starting and the node finishing, since the step's deadline covers all of its retries
+func syncNodeStatus(nodeStatus argov1alpha1.NodeStatus, startedAt metav1.Time, step *testv1alpha1.TestStep, status *testv1alpha1.StepStatus) {
+	status.Status = toPluralStatus(string(nodeStatus.Phase))
+	if step != nil && timedOut(string(nodeStatus.Phase), startedAt, nodeStatus.FinishedAt, step.Timeout) {
+		status.Reason = testv1alpha1.ReasonTimedOut
+	}
+}
+
+func workflowTimedOut(wf *argov1alpha1.Workflow, suite *testv1alpha1.TestSuite) bool {
+	return timedOut(string(wf.Status.Phase), wf.Status.StartedAt, wf.Status.FinishedAt, suite.Spec.Timeout)
+}
+
+// firstAttempt finds the earliest pod node under a retry node, falling back to the retry node itself
+func firstAttempt(wf *argov1alpha1.Workflow, retry argov1alpha1.NodeStatus) argov1alpha1.NodeStatus {
+	for _, child := range retry.Children {
+		if node, ok := wf.Status.Nodes[child]; ok {
+			return node
+		}
+	}
+	return retry
+}
+
+func hasRetryNode(wf *argov1alpha1.Workflow, template string) bool {
+	for _, nodeStatus := range wf.Status.Nodes {
+		if nodeStatus.Type == argov1alpha1.NodeTypeRetry && nodeStatus.TemplateName == template {
+			return true
+		}
+	}
+	return false
+}
+
+func suiteToWorkflow(suite *testv1alpha1.TestSuite) (workflow argov1alpha1.Workflow, err error) {
+	if err = suite.ValidateSpec(); err != nil {
+		return
+	}
+
+	deps, err := suite.Spec.StepDependencies()
+	if err != nil {
+		return
+	}
+
+	workflow.Name = workflowName(suite)
+	workflow.Namespace = suite.Namespace
+	workflow.Annotations = map[string]string{}
+	workflow.Annotations[ownedAnnotation] = suite.Name
+	workflow.Labels = runLabels(suite)
+
+	workflow.Spec.Entrypoint = testv1alpha1.EntrypointName
+	workflow.Spec.ServiceAccountName = serviceAccountName
+	workflow.Spec.PodMetadata = &argov1alpha1.Metadata{Labels: runLabels(suite)}
+	if suite.Spec.Suspend {
+		workflow.Spec.Suspend = &suite.Spec.Suspend
+	}
+	if suite.Spec.Timeout != nil {
+		workflow.Spec.ActiveDeadlineSeconds = utils.Int64(deadlineSeconds(suite.Spec.Timeout))
+	}
+	templates := make([]argov1alpha1.Template, 0)
+	for _, step := range suite.Spec.Steps {
+		step.Template.Name = step.Name
+		if step.Retry != nil {
+			step.Template.RetryStrategy = toRetryStrategy(step.Retry, step.Timeout)
+		}
+		if step.Timeout != nil {
+			deadline := intstr.FromInt(int(deadlineSeconds(step.Timeout)))
+			step.Template.ActiveDeadlineSeconds = &deadline
+		}
+		templates = append(templates, *step.Template)
+	}
+
+	dag := &argov1alpha1.DAGTemplate{}
+	tasks := make([]argov1alpha1.DAGTask, 0)
+	for _, step := range suite.Spec.Steps {
+		tasks = append(tasks, argov1alpha1.DAGTask{
+			Name:         step.Name,
+			Template:     step.Name,
+			Dependencies: deps[step.Name],
+		})
+	}
+	dag.Tasks = tasks
+	workflow.Spec.Templates = append(templates, argov1alpha1.Template{DAG: dag, Name: testv1alpha1.EntrypointName})
+	return
+}
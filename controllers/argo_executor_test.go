@@ -0,0 +1,250 @@
+package controllers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	argov1alpha1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
+	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
+	"github.com/pluralsh/test-harness/pkg/plural"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestWorkflowPodName(t *testing.T) {
+	wf := &argov1alpha1.Workflow{ObjectMeta: metav1.ObjectMeta{Name: "suite-1"}}
+	v1 := &argov1alpha1.Workflow{ObjectMeta: metav1.ObjectMeta{Name: "suite-1", Annotations: map[string]string{podNameFormatAnnotation: "v1"}}}
+	long := &argov1alpha1.Workflow{ObjectMeta: metav1.ObjectMeta{Name: strings.Repeat("s", 250)}}
+
+	cases := []struct {
+		name     string
+		wf       *argov1alpha1.Workflow
+		node     argov1alpha1.NodeStatus
+		expected string
+	}{
+		{"hashes the node name", wf, argov1alpha1.NodeStatus{ID: "suite-1-123", Name: "suite-1(0).first", TemplateName: "first"}, "suite-1-first-1536492444"},
+		{"retries get their own pod", wf, argov1alpha1.NodeStatus{ID: "suite-1-456", Name: "suite-1.first(1)", TemplateName: "first"}, "suite-1-first-1162350849"},
+		{"single pod workflows", wf, argov1alpha1.NodeStatus{ID: "suite-1", Name: "suite-1", TemplateName: "first"}, "suite-1"},
+		{"v1 names pods after the node id", v1, argov1alpha1.NodeStatus{ID: "suite-1-123", Name: "suite-1(0).first", TemplateName: "first"}, "suite-1-123"},
+		{"truncates long prefixes", long, argov1alpha1.NodeStatus{ID: "id", Name: "suite-1(0).first", TemplateName: "first"}, strings.Repeat("s", 242) + "-1536492444"},
+	}
+
+	for _, c := range cases {
+		if name := workflowPodName(c.wf, c.node); name != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.name, c.expected, name)
+		}
+	}
+}
+
+func TestToRetryStrategy(t *testing.T) {
+	hour := &metav1.Duration{Duration: time.Hour}
+	limit := intstr.FromInt(2)
+	factor := intstr.FromInt(2)
+	two := int32(2)
+
+	cases := []struct {
+		name     string
+		retry    *testv1alpha1.RetryPolicy
+		timeout  *metav1.Duration
+		expected *argov1alpha1.RetryStrategy
+	}{
+		{
+			"retries failures by default",
+			&testv1alpha1.RetryPolicy{Limit: 2},
+			nil,
+			&argov1alpha1.RetryStrategy{Limit: &limit, RetryPolicy: argov1alpha1.RetryPolicyOnFailure},
+		},
+		{
+			"maps the retry policy",
+			&testv1alpha1.RetryPolicy{Limit: 2, RetryOn: testv1alpha1.RetryOnAlways},
+			nil,
+			&argov1alpha1.RetryStrategy{Limit: &limit, RetryPolicy: argov1alpha1.RetryPolicyAlways},
+		},
+		{
+			"copies the backoff",
+			&testv1alpha1.RetryPolicy{Limit: 2, RetryOn: testv1alpha1.RetryOnError, Backoff: &testv1alpha1.RetryBackoff{Duration: "30s", Factor: &two, MaxDuration: "5m"}},
+			nil,
+			&argov1alpha1.RetryStrategy{Limit: &limit, RetryPolicy: argov1alpha1.RetryPolicyOnError, Backoff: &argov1alpha1.Backoff{Duration: "30s", Factor: &factor, MaxDuration: "5m"}},
+		},
+		{
+			"caps the retries at the timeout",
+			&testv1alpha1.RetryPolicy{Limit: 2},
+			hour,
+			&argov1alpha1.RetryStrategy{Limit: &limit, RetryPolicy: argov1alpha1.RetryPolicyOnFailure, Backoff: &argov1alpha1.Backoff{Duration: "0", MaxDuration: "1h0m0s"}},
+		},
+		{
+			"keeps a shorter max duration",
+			&testv1alpha1.RetryPolicy{Limit: 2, Backoff: &testv1alpha1.RetryBackoff{Duration: "30s", MaxDuration: "600"}},
+			hour,
+			&argov1alpha1.RetryStrategy{Limit: &limit, RetryPolicy: argov1alpha1.RetryPolicyOnFailure, Backoff: &argov1alpha1.Backoff{Duration: "30s", MaxDuration: "600"}},
+		},
+		{
+			"replaces a longer max duration",
+			&testv1alpha1.RetryPolicy{Limit: 2, Backoff: &testv1alpha1.RetryBackoff{MaxDuration: "2h"}},
+			hour,
+			&argov1alpha1.RetryStrategy{Limit: &limit, RetryPolicy: argov1alpha1.RetryPolicyOnFailure, Backoff: &argov1alpha1.Backoff{Duration: "0", MaxDuration: "1h0m0s"}},
+		},
+	}
+
+	for _, c := range cases {
+		if strategy := toRetryStrategy(c.retry, c.timeout); !reflect.DeepEqual(strategy, c.expected) {
+			t.Errorf("%s: expected %+v, got %+v", c.name, c.expected, strategy)
+		}
+	}
+}
+
+func TestTimedOut(t *testing.T) {
+	start := metav1.NewTime(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	at := func(d time.Duration) metav1.Time { return metav1.NewTime(start.Add(d)) }
+	minute := &metav1.Duration{Duration: time.Minute}
+
+	cases := []struct {
+		name     string
+		phase    string
+		finished metav1.Time
+		timeout  *metav1.Duration
+		expected bool
+	}{
+		{"no timeout", "Failed", at(time.Hour), nil, false},
+		{"failed past the deadline", "Failed", at(time.Minute), minute, true},
+		{"errored past the deadline", "Error", at(2 * time.Minute), minute, true},
+		{"failed before the deadline", "Failed", at(30 * time.Second), minute, false},
+		{"succeeded past the deadline", "Succeeded", at(time.Hour), minute, false},
+		{"still running", "Running", metav1.Time{}, minute, false},
+		{"rounds the deadline up to the second", "Failed", at(time.Minute), &metav1.Duration{Duration: time.Minute + time.Millisecond}, false},
+	}
+
+	for _, c := range cases {
+		if res := timedOut(c.phase, start, c.finished, c.timeout); res != c.expected {
+			t.Errorf("%s: expected timed out to be %v", c.name, c.expected)
+		}
+	}
+}
+
+func TestSyncNodeStatusAcrossRetries(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) metav1.Time { return metav1.NewTime(start.Add(d)) }
+	step := &testv1alpha1.TestStep{Name: "first", Retry: &testv1alpha1.RetryPolicy{Limit: 2}, Timeout: &metav1.Duration{Duration: time.Minute}}
+
+	// neither attempt ran for a minute, but the step as a whole did
+	wf := &argov1alpha1.Workflow{Status: argov1alpha1.WorkflowStatus{Nodes: argov1alpha1.Nodes{
+		"retry":   {ID: "retry", Type: argov1alpha1.NodeTypeRetry, TemplateName: "first", Phase: argov1alpha1.NodeFailed, StartedAt: at(0), FinishedAt: at(70 * time.Second), Children: []string{"first-0", "first-1"}},
+		"first-0": {ID: "first-0", Type: argov1alpha1.NodeTypePod, TemplateName: "first", Phase: argov1alpha1.NodeFailed, StartedAt: at(time.Second), FinishedAt: at(40 * time.Second)},
+		"first-1": {ID: "first-1", Type: argov1alpha1.NodeTypePod, TemplateName: "first", Phase: argov1alpha1.NodeFailed, StartedAt: at(45 * time.Second), FinishedAt: at(70 * time.Second)},
+	}}}
+
+	retry := wf.Status.Nodes["retry"]
+	status := &testv1alpha1.StepStatus{}
+	syncNodeStatus(retry, firstAttempt(wf, retry).StartedAt, step, status)
+	if status.Status != plural.StatusFailed || status.Reason != testv1alpha1.ReasonTimedOut {
+		t.Errorf("expected the step to have timed out, got %s %s", status.Status, status.Reason)
+	}
+}
+
+func TestSyncWorkflowStatus(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) metav1.Time { return metav1.NewTime(start.Add(d)) }
+	pod := func(id, template string, phase argov1alpha1.NodePhase, started, finished time.Duration) argov1alpha1.NodeStatus {
+		return argov1alpha1.NodeStatus{ID: id, Type: argov1alpha1.NodeTypePod, TemplateName: template, Phase: phase, StartedAt: at(started), FinishedAt: at(finished)}
+	}
+	retry := func(template string, phase argov1alpha1.NodePhase, children ...string) argov1alpha1.NodeStatus {
+		return argov1alpha1.NodeStatus{ID: template, Type: argov1alpha1.NodeTypeRetry, TemplateName: template, Phase: phase, Children: children}
+	}
+	minute := &metav1.Duration{Duration: time.Minute}
+
+	cases := []struct {
+		name     string
+		phase    argov1alpha1.WorkflowPhase
+		finished time.Duration
+		timeout  *metav1.Duration
+		steps    []*testv1alpha1.TestStep
+		nodes    argov1alpha1.Nodes
+		status   plural.Status
+		reason   string
+		expected []testv1alpha1.StepStatus
+	}{
+		{
+			name:  "takes each step from its pod",
+			phase: argov1alpha1.WorkflowRunning,
+			steps: []*testv1alpha1.TestStep{{Name: "first"}, {Name: "second"}},
+			nodes: argov1alpha1.Nodes{
+				"first-1":  pod("first-1", "first", argov1alpha1.NodeSucceeded, 0, 10*time.Second),
+				"second-1": pod("second-1", "second", argov1alpha1.NodeRunning, 10*time.Second, 0),
+			},
+			status: plural.StatusRunning,
+			expected: []testv1alpha1.StepStatus{
+				{Name: "first", Status: plural.StatusSucceeded, Attempts: 1, Pods: []string{"first-1"}},
+				{Name: "second", Status: plural.StatusRunning, Attempts: 1, Pods: []string{"second-1"}},
+			},
+		},
+		{
+			name:  "takes a retried step from its retry node with a pod per attempt",
+			phase: argov1alpha1.WorkflowSucceeded,
+			steps: []*testv1alpha1.TestStep{{Name: "first", Retry: &testv1alpha1.RetryPolicy{Limit: 2}}},
+			nodes: argov1alpha1.Nodes{
+				"first":   retry("first", argov1alpha1.NodeSucceeded, "first-1", "first-2"),
+				"first-2": pod("first-2", "first", argov1alpha1.NodeSucceeded, 20*time.Second, 30*time.Second),
+				"first-1": pod("first-1", "first", argov1alpha1.NodeFailed, 0, 10*time.Second),
+			},
+			status: plural.StatusSucceeded,
+			expected: []testv1alpha1.StepStatus{
+				{Name: "first", Status: plural.StatusSucceeded, Attempts: 2, Pods: []string{"first-1", "first-2"}},
+			},
+		},
+		{
+			name:  "marks a step that ran past its timeout",
+			phase: argov1alpha1.WorkflowFailed,
+			steps: []*testv1alpha1.TestStep{{Name: "first", Timeout: minute}},
+			nodes: argov1alpha1.Nodes{
+				"first-1": pod("first-1", "first", argov1alpha1.NodeFailed, 0, time.Minute),
+			},
+			status: plural.StatusFailed,
+			expected: []testv1alpha1.StepStatus{
+				{Name: "first", Status: plural.StatusFailed, Reason: testv1alpha1.ReasonTimedOut, Attempts: 1, Pods: []string{"first-1"}},
+			},
+		},
+		{
+			name:     "marks a workflow that ran past the suite timeout",
+			phase:    argov1alpha1.WorkflowFailed,
+			finished: 2 * time.Minute,
+			timeout:  minute,
+			steps:    []*testv1alpha1.TestStep{{Name: "first"}},
+			nodes: argov1alpha1.Nodes{
+				"first-1": pod("first-1", "first", argov1alpha1.NodeFailed, 0, 2*time.Minute),
+			},
+			status: plural.StatusFailed,
+			reason: testv1alpha1.ReasonTimedOut,
+			expected: []testv1alpha1.StepStatus{
+				{Name: "first", Status: plural.StatusFailed, Attempts: 1, Pods: []string{"first-1"}},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		suite := &testv1alpha1.TestSuite{Spec: testv1alpha1.TestSuiteSpec{Steps: c.steps, Timeout: c.timeout}}
+		for _, step := range c.steps {
+			suite.Status.Steps = append(suite.Status.Steps, &testv1alpha1.StepStatus{Name: step.Name, Status: plural.StatusQueued})
+		}
+		wf := &argov1alpha1.Workflow{
+			ObjectMeta: metav1.ObjectMeta{Name: "suite-1", Annotations: map[string]string{podNameFormatAnnotation: "v1"}},
+			Status:     argov1alpha1.WorkflowStatus{Phase: c.phase, StartedAt: at(0), Nodes: c.nodes},
+		}
+		if c.finished > 0 {
+			wf.Status.FinishedAt = at(c.finished)
+		}
+
+		syncWorkflowStatus(wf, suite)
+		if suite.Status.Status != c.status || suite.Status.Reason != c.reason {
+			t.Errorf("%s: expected suite %s %q, got %s %q", c.name, c.status, c.reason, suite.Status.Status, suite.Status.Reason)
+		}
+		steps := make([]testv1alpha1.StepStatus, 0)
+		for _, step := range suite.Status.Steps {
+			steps = append(steps, *step)
+		}
+		if !reflect.DeepEqual(steps, c.expected) {
+			t.Errorf("%s: expected steps %+v, got %+v", c.name, c.expected, steps)
+		}
+	}
+}
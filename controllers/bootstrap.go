@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// bootstrap registers a new run of the suite with plural and starts it on the executor.  Each stage is persisted
+// before moving on to the next, so a failure partway through is picked up again on retry rather than
+// registering a duplicate plural test or orphaning a run.
+func (r *TestSuiteReconciler) bootstrap(ctx context.Context, log logr.Logger, suite *testv1alpha1.TestSuite) (ctrl.Result, error) {
+	// the webhook is optional, so this is where most users find out their suite is invalid
+	if err := r.Executor.Validate(suite); err != nil {
+		log.Error(err, "invalid testsuite")
+		setCondition(suite, testv1alpha1.ConditionReady, metav1.ConditionFalse, testv1alpha1.ReasonInvalidSpec, err.Error())
+		suite.Status.ObservedGeneration = suite.Generation
+		if err := r.Status().Update(ctx, suite); err != nil {
+			log.Error(err, "failed to record invalid spec")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+	setCondition(suite, testv1alpha1.ConditionReady, metav1.ConditionTrue, "Valid", "the test suite spec is valid")
+
+	if suite.Status.PluralId == "" {
+		suite.Status.Runs++
+		initSuiteStatus(suite)
+	}
+
+	suite.Status.RerunToken = suite.Annotations[rerunAnnotation]
+	suite.Status.ObservedGeneration = suite.Generation
+	if suite.Status.PluralId == "" {
+		tst, err := r.Plural.CreateTest(suite.Spec.Repository, suiteToPluralTest(suite))
+		if err != nil {
+			log.Error(err, "failed to create plural test")
+			return ctrl.Result{}, err
+		}
+
+		suite.Status.PluralId = tst.Id
+		statuses := stepStatuses(suite)
+		for _, step := range tst.Steps {
+			if status, ok := statuses[step.Name]; ok {
+				status.PluralId = step.Id
+			}
+		}
+		setCondition(suite, testv1alpha1.ConditionPluralRegistered, metav1.ConditionTrue, "Registered", "the test was registered with plural")
+		setCondition(suite, testv1alpha1.ConditionWorkflowCreated, metav1.ConditionFalse, "Pending", "waiting to start the run")
+
+		if err := r.Status().Update(ctx, suite); err != nil {
+			log.Error(err, "failed to record plural test")
+			return ctrl.Result{}, err
+		}
+	}
+
+	log.Info("Starting new run of testsuite")
+	name, err := r.Executor.Create(ctx, suite)
+	if err != nil {
+		log.Error(err, "failed to start run")
+		return ctrl.Result{}, err
+	}
+
+	suite.Status.WorkflowName = name
+	setCondition(suite, testv1alpha1.ConditionWorkflowCreated, metav1.ConditionTrue, "Created", fmt.Sprintf("started run %s", name))
+	setCondition(suite, testv1alpha1.ConditionLogsStreaming, metav1.ConditionFalse, "Pending", "waiting for step pods to start")
+	syncCompletionConditions(suite)
+
+	if err := r.Status().Update(ctx, suite); err != nil {
+		log.Error(err, "failed to update suite status")
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"context"
+
+	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
+	"github.com/pluralsh/test-harness/pkg/plural"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// markCancelled marks the suite and any unfinished steps as cancelled, for runs that were terminated.  Log
+// watchers are cancelled once the suite is seen as completed.
+func markCancelled(suite *testv1alpha1.TestSuite) {
+	for _, step := range suite.Status.Steps {
+		if step.Status == plural.StatusQueued || step.Status == plural.StatusRunning {
+			cancelStep(step)
+		}
+	}
+	finishCancelled(suite)
+}
+
+// markStopped cancels the steps a stopped run will never start, leaving running ones to report their real
+// outcome, and cancels the suite itself once they've finished
+func markStopped(suite *testv1alpha1.TestSuite) {
+	finished := suite.Status.Status == plural.StatusSucceeded || suite.Status.Status == plural.StatusFailed
+	unfinished := 0
+	for _, step := range suite.Status.Steps {
+		switch {
+		case step.Status == plural.StatusQueued && len(step.Pods) == 0:
+			cancelStep(step)
+		case step.Status == plural.StatusQueued || step.Status == plural.StatusRunning:
+			unfinished++
+		}
+	}
+
+	if finished || unfinished == 0 {
+		finishCancelled(suite)
+	}
+}
+
+func cancelStep(step *testv1alpha1.StepStatus) {
+	step.Status = plural.StatusCancelled
+	step.Reason = testv1alpha1.ReasonCancelled
+}
+
+func finishCancelled(suite *testv1alpha1.TestSuite) {
+	suite.Status.Status = plural.StatusCancelled
+	suite.Status.Reason = testv1alpha1.ReasonCancelled
+	if suite.Status.CompletionTime == nil {
+		t := metav1.Now()
+		suite.Status.CompletionTime = &t
+	}
+	setCondition(suite, testv1alpha1.ConditionLogsStreaming, metav1.ConditionFalse, "Cancelled", "the test suite was cancelled")
+}
+
+// stopRun terminates the suite's current run ahead of a rerun and gives its plural test a final status, since
+// neither is looked at again once the run is archived
+func (r *TestSuiteReconciler) stopRun(ctx context.Context, suite *testv1alpha1.TestSuite) error {
+	if suiteCompleted(suite) {
+		return nil
+	}
+
+	terminate := suite.DeepCopy()
+	terminate.Spec.Cancel = testv1alpha1.CancelTerminate
+	if err := r.Executor.Cancel(ctx, terminate); err != nil {
+		return err
+	}
+
+	markCancelled(suite)
+	return r.syncPluralTest(suite)
+}
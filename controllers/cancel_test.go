@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
+	"github.com/pluralsh/test-harness/pkg/plural"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	queuedStep    = testv1alpha1.StepStatus{Status: plural.StatusQueued}
+	startedStep   = testv1alpha1.StepStatus{Status: plural.StatusQueued, Pods: []string{"pod"}}
+	runningStep   = testv1alpha1.StepStatus{Status: plural.StatusRunning, Pods: []string{"pod"}}
+	succeededStep = testv1alpha1.StepStatus{Status: plural.StatusSucceeded, Pods: []string{"pod"}}
+	failedStep    = testv1alpha1.StepStatus{Status: plural.StatusFailed, Pods: []string{"pod"}}
+	cancelledStep = testv1alpha1.StepStatus{Status: plural.StatusCancelled, Reason: testv1alpha1.ReasonCancelled}
+)
+
+func TestMarkCancelled(t *testing.T) {
+	cancelledRunning := testv1alpha1.StepStatus{Status: plural.StatusCancelled, Reason: testv1alpha1.ReasonCancelled, Pods: []string{"pod"}}
+
+	cases := []struct {
+		name     string
+		steps    []testv1alpha1.StepStatus
+		expected []testv1alpha1.StepStatus
+	}{
+		{"cancels queued steps", []testv1alpha1.StepStatus{queuedStep, queuedStep}, []testv1alpha1.StepStatus{cancelledStep, cancelledStep}},
+		{"cancels running steps", []testv1alpha1.StepStatus{succeededStep, runningStep, queuedStep}, []testv1alpha1.StepStatus{succeededStep, cancelledRunning, cancelledStep}},
+		{"keeps finished steps", []testv1alpha1.StepStatus{succeededStep, failedStep}, []testv1alpha1.StepStatus{succeededStep, failedStep}},
+	}
+
+	for _, c := range cases {
+		suite := cancelSuite(plural.StatusRunning, c.steps)
+		markCancelled(suite)
+		if !reflect.DeepEqual(stepValues(suite), c.expected) {
+			t.Errorf("%s: expected steps %+v, got %+v", c.name, c.expected, stepValues(suite))
+		}
+		if !cancelFinished(suite) {
+			t.Errorf("%s: expected the suite to be cancelled, got %+v", c.name, suite.Status)
+		}
+	}
+}
+
+func TestMarkStopped(t *testing.T) {
+	cases := []struct {
+		name     string
+		status   plural.Status
+		steps    []testv1alpha1.StepStatus
+		expected []testv1alpha1.StepStatus
+		finished bool
+	}{
+		{
+			name:     "cancels steps that never started",
+			status:   plural.StatusQueued,
+			steps:    []testv1alpha1.StepStatus{queuedStep, queuedStep},
+			expected: []testv1alpha1.StepStatus{cancelledStep, cancelledStep},
+			finished: true,
+		},
+		{
+			name:     "waits on running steps",
+			status:   plural.StatusRunning,
+			steps:    []testv1alpha1.StepStatus{succeededStep, runningStep, queuedStep},
+			expected: []testv1alpha1.StepStatus{succeededStep, runningStep, cancelledStep},
+		},
+		{
+			name:     "waits on steps with pods that haven't reported yet",
+			status:   plural.StatusRunning,
+			steps:    []testv1alpha1.StepStatus{startedStep, queuedStep},
+			expected: []testv1alpha1.StepStatus{startedStep, cancelledStep},
+		},
+		{
+			name:     "cancels the suite once running steps finish",
+			status:   plural.StatusRunning,
+			steps:    []testv1alpha1.StepStatus{succeededStep, failedStep, queuedStep},
+			expected: []testv1alpha1.StepStatus{succeededStep, failedStep, cancelledStep},
+			finished: true,
+		},
+		{
+			name:     "cancels a finished run",
+			status:   plural.StatusFailed,
+			steps:    []testv1alpha1.StepStatus{failedStep, runningStep},
+			expected: []testv1alpha1.StepStatus{failedStep, runningStep},
+			finished: true,
+		},
+	}
+
+	for _, c := range cases {
+		suite := cancelSuite(c.status, c.steps)
+		markStopped(suite)
+		if !reflect.DeepEqual(stepValues(suite), c.expected) {
+			t.Errorf("%s: expected steps %+v, got %+v", c.name, c.expected, stepValues(suite))
+		}
+		if finished := cancelFinished(suite); finished != c.finished {
+			t.Errorf("%s: expected the suite to be cancelled to be %v, got %+v", c.name, c.finished, suite.Status)
+		}
+		if !c.finished && suite.Status.Status != c.status {
+			t.Errorf("%s: expected the suite to stay %s, got %s", c.name, c.status, suite.Status.Status)
+		}
+	}
+}
+
+func cancelSuite(status plural.Status, steps []testv1alpha1.StepStatus) *testv1alpha1.TestSuite {
+	suite := &testv1alpha1.TestSuite{Status: testv1alpha1.TestSuiteStatus{Status: status}}
+	for i := range steps {
+		step := steps[i]
+		suite.Status.Steps = append(suite.Status.Steps, &step)
+	}
+	return suite
+}
+
+func stepValues(suite *testv1alpha1.TestSuite) []testv1alpha1.StepStatus {
+	res := make([]testv1alpha1.StepStatus, 0)
+	for _, step := range suite.Status.Steps {
+		res = append(res, *step)
+	}
+	return res
+}
+
+// cancelFinished checks the suite was given its final cancelled status, with log streaming stopped
+func cancelFinished(suite *testv1alpha1.TestSuite) bool {
+	cond := meta.FindStatusCondition(suite.Status.Conditions, testv1alpha1.ConditionLogsStreaming)
+	return suite.Status.Status == plural.StatusCancelled &&
+		suite.Status.Reason == testv1alpha1.ReasonCancelled &&
+		suite.Status.CompletionTime != nil &&
+		cond != nil && cond.Status == metav1.ConditionFalse
+}
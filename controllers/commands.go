@@ -0,0 +1,236 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
+	"github.com/pluralsh/test-harness/pkg/plural"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	CommandCancel  = "cancel"
+	CommandRerun   = "rerun"
+	CommandPromote = "promote"
+
+	commandTimeout = 30 * time.Second
+	// how often subscriptions are checked against the testsuites even when nothing asked for it
+	commandResync = 5 * time.Minute
+
+	pluralIdField = "status.pluralId"
+)
+
+// Command is sent by plural over a repository's test channel to drive a test from the console
+type Command struct {
+	// the plural id of the test the command is for
+	Test string `json:"test"`
+	// the cancel strategy, defaults to Stop
+	Strategy testv1alpha1.CancelStrategy `json:"strategy,omitempty"`
+	// the tag to promote to
+	Tag string `json:"tag,omitempty"`
+}
+
+// CommandListener subscribes to the test channel of each repository with a testsuite on the plural socket and
+// applies the commands sent over it to the matching testsuite, as the equivalent spec change or annotation.  It
+// runs alongside the controllers, so joining and leaving channels never holds up a reconcile.
+type CommandListener struct {
+	client.Client
+	Socket *plural.Socket
+	Log    logr.Logger
+
+	// the manager's cache, which holds the plural id index even though the client reads testsuites uncached
+	cache    client.Reader
+	refresh  chan struct{}
+	channels map[string]*plural.Channel
+}
+
+type commandReceiver struct {
+	listener *CommandListener
+	repo     string
+}
+
+// SetupWithManager indexes testsuites by plural id for looking up the target of a command, and runs the listener
+// with the manager
+func (l *CommandListener) SetupWithManager(mgr ctrl.Manager) error {
+	l.refresh = make(chan struct{}, 1)
+	l.cache = mgr.GetCache()
+	l.channels = map[string]*plural.Channel{}
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &testv1alpha1.TestSuite{}, pluralIdField, func(obj client.Object) []string {
+		id := obj.(*testv1alpha1.TestSuite).Status.PluralId
+		if id == "" {
+			return nil
+		}
+		return []string{id}
+	})
+	if err != nil {
+		return err
+	}
+	return mgr.Add(l)
+}
+
+// Refresh asks the listener to bring its subscriptions in line with the testsuites, without waiting for it
+func (l *CommandListener) Refresh() {
+	select {
+	case l.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// Start keeps a channel joined for every repository with a testsuite until the manager stops
+func (l *CommandListener) Start(ctx context.Context) error {
+	l.Socket.Start()
+	ticker := time.NewTicker(commandResync)
+	defer ticker.Stop()
+	for {
+		if err := l.sync(ctx); err != nil {
+			l.Log.Error(err, "failed to sync command channels")
+		}
+
+		select {
+		case <-ctx.Done():
+			for repo := range l.channels {
+				l.leave(repo)
+			}
+			return nil
+		case <-l.refresh:
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync joins the channels of repositories that gained a testsuite and leaves those of repositories that lost their last one
+func (l *CommandListener) sync(ctx context.Context) error {
+	var suites testv1alpha1.TestSuiteList
+	if err := l.reader().List(ctx, &suites); err != nil {
+		return err
+	}
+
+	repos := map[string]bool{}
+	for _, suite := range suites.Items {
+		if suite.DeletionTimestamp.IsZero() && suite.Spec.Repository != "" {
+			repos[suite.Spec.Repository] = true
+		}
+	}
+
+	for repo := range l.channels {
+		if !repos[repo] {
+			l.leave(repo)
+		}
+	}
+	for repo := range repos {
+		if _, ok := l.channels[repo]; ok {
+			continue
+		}
+
+		ch, err := l.Socket.Join(&commandReceiver{listener: l, repo: repo}, commandTopic(repo))
+		if err != nil {
+			l.Log.Error(err, "failed to join command channel", "repository", repo)
+		}
+		l.channels[repo] = ch
+	}
+	return nil
+}
+
+func (l *CommandListener) leave(repo string) {
+	if err := l.channels[repo].Leave(); err != nil {
+		l.Log.Error(err, "failed to leave command channel", "repository", repo)
+	}
+	delete(l.channels, repo)
+}
+
+func (l *CommandListener) handle(event string, payload interface{}) error {
+	var cmd Command
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, &cmd); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	suite, err := l.findSuite(ctx, cmd.Test)
+	if err != nil {
+		return err
+	}
+
+	patch := client.MergeFrom(suite.DeepCopy())
+	switch event {
+	case CommandCancel:
+		suite.Spec.Cancel = testv1alpha1.CancelStop
+		if cmd.Strategy != "" {
+			suite.Spec.Cancel = cmd.Strategy
+		}
+	case CommandRerun:
+		// a cancelled suite can't be rerun until its cancel is cleared
+		suite.Spec.Cancel = ""
+		if suite.Annotations == nil {
+			suite.Annotations = map[string]string{}
+		}
+		suite.Annotations[rerunAnnotation] = fmt.Sprint(time.Now().UnixNano())
+	case CommandPromote:
+		if cmd.Tag == "" {
+			return fmt.Errorf("no tag given to promote test %s to", cmd.Test)
+		}
+		suite.Spec.PromoteTag = cmd.Tag
+	default:
+		return fmt.Errorf("unknown command %s", event)
+	}
+
+	l.Log.Info("applying plural command", "command", event, "testsuite", client.ObjectKeyFromObject(suite))
+	return l.Patch(ctx, suite, patch)
+}
+
+func (l *CommandListener) findSuite(ctx context.Context, id string) (*testv1alpha1.TestSuite, error) {
+	if id == "" {
+		return nil, fmt.Errorf("command has no test id")
+	}
+
+	var suites testv1alpha1.TestSuiteList
+	if err := l.reader().List(ctx, &suites, client.MatchingFields{pluralIdField: id}); err != nil {
+		return nil, err
+	}
+
+	for i := range suites.Items {
+		if suites.Items[i].Status.PluralId == id {
+			return &suites.Items[i], nil
+		}
+	}
+	return nil, fmt.Errorf("could not find testsuite for plural test %s", id)
+}
+
+// reader lists testsuites from the cache, as the api server can't select them by plural id
+func (l *CommandListener) reader() client.Reader {
+	if l.cache != nil {
+		return l.cache
+	}
+	return l.Client
+}
+
+func (r *commandReceiver) OnJoin(payload interface{}) {
+	r.listener.Log.Info("listening for plural commands", "repository", r.repo)
+}
+
+func (r *commandReceiver) OnJoinError(payload interface{}) {
+	r.listener.Log.Info("failed to join command channel", "repository", r.repo, "payload", payload)
+}
+
+func (r *commandReceiver) OnChannelClose(payload interface{}) {
+	r.listener.Log.Info("command channel closed", "repository", r.repo)
+}
+
+func (r *commandReceiver) OnMessage(ref int64, event string, payload interface{}) {
+	if err := r.listener.handle(event, payload); err != nil {
+		r.listener.Log.Error(err, "failed to apply plural command", "command", event, "repository", r.repo)
+	}
+}
+
+func commandTopic(repo string) string {
+	return fmt.Sprintf("tests:repository:%s", repo)
+}
@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
+	"github.com/pluralsh/test-harness/pkg/plural"
+	"github.com/pluralsh/test-harness/pkg/plural/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCommandListenerSync(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := testv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	suite := func(name, repo string) *testv1alpha1.TestSuite {
+		return &testv1alpha1.TestSuite{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       testv1alpha1.TestSuiteSpec{Repository: repo},
+		}
+	}
+	first, second := suite("first", "console"), suite("second", "console")
+	kube := crfake.NewClientBuilder().WithScheme(scheme).WithObjects(first, second, suite("other", "airflow")).Build()
+
+	srv := fake.NewServer("token")
+	defer srv.Close()
+	socket := plural.NewSocket(srv.Config())
+	defer socket.Close()
+	l := &CommandListener{Client: kube, Socket: socket, Log: ctrl.Log, channels: map[string]*plural.Channel{}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	subscribed := func(expected ...string) {
+		t.Helper()
+		if err := l.sync(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if len(l.channels) != len(expected) {
+			t.Fatalf("expected channels for %v, got %v", expected, l.channels)
+		}
+		for _, repo := range expected {
+			if _, ok := l.channels[repo]; !ok {
+				t.Fatalf("expected a channel for %s, got %v", repo, l.channels)
+			}
+		}
+	}
+
+	subscribed("console", "airflow")
+
+	// a repository keeps its channel until its last suite is gone
+	if err := kube.Delete(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	subscribed("console", "airflow")
+	if err := kube.Delete(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+	subscribed("airflow")
+}
+
+// apiServerClient rejects field selectors the way the api server does for custom resources
+type apiServerClient struct {
+	client.Client
+}
+
+func (c *apiServerClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOpts := &client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	if listOpts.FieldSelector != nil && !listOpts.FieldSelector.Empty() {
+		return fmt.Errorf("field label not supported: %s", listOpts.FieldSelector)
+	}
+	return c.Client.List(ctx, list, opts...)
+}
+
+func TestCommandListenerHandle(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := testv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	suite := func(name, id string) *testv1alpha1.TestSuite {
+		return &testv1alpha1.TestSuite{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Status:     testv1alpha1.TestSuiteStatus{PluralId: id},
+		}
+	}
+	kube := crfake.NewClientBuilder().WithScheme(scheme).WithObjects(suite("first", "test-1"), suite("second", "test-2")).Build()
+	// testsuites are read uncached by the manager's client, so lookups have to go through the cache's index
+	l := &CommandListener{Client: &apiServerClient{Client: kube}, cache: kube, Log: ctrl.Log}
+
+	cases := []struct {
+		event   string
+		cmd     Command
+		check   func(*testv1alpha1.TestSuite) bool
+		invalid bool
+	}{
+		{CommandCancel, Command{Test: "test-2"}, func(s *testv1alpha1.TestSuite) bool { return s.Spec.Cancel == testv1alpha1.CancelStop }, false},
+		{CommandCancel, Command{Test: "test-2", Strategy: testv1alpha1.CancelTerminate}, func(s *testv1alpha1.TestSuite) bool { return s.Spec.Cancel == testv1alpha1.CancelTerminate }, false},
+		{CommandRerun, Command{Test: "test-2"}, func(s *testv1alpha1.TestSuite) bool {
+			return s.Spec.Cancel == "" && s.Annotations[rerunAnnotation] != ""
+		}, false},
+		{CommandPromote, Command{Test: "test-2", Tag: "warm"}, func(s *testv1alpha1.TestSuite) bool { return s.Spec.PromoteTag == "warm" }, false},
+		{CommandPromote, Command{Test: "test-2"}, nil, true},
+		{CommandCancel, Command{Test: "unknown"}, nil, true},
+		{CommandCancel, Command{}, nil, true},
+		{"unknown", Command{Test: "test-2"}, nil, true},
+	}
+
+	ctx := context.Background()
+	for _, c := range cases {
+		err := l.handle(c.event, c.cmd)
+		if c.invalid {
+			if err == nil {
+				t.Errorf("%s %+v: expected the command to be rejected", c.event, c.cmd)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s %+v: %v", c.event, c.cmd, err)
+		}
+
+		var target, other testv1alpha1.TestSuite
+		if err := kube.Get(ctx, client.ObjectKey{Name: "second", Namespace: "default"}, &target); err != nil {
+			t.Fatal(err)
+		}
+		if err := kube.Get(ctx, client.ObjectKey{Name: "first", Namespace: "default"}, &other); err != nil {
+			t.Fatal(err)
+		}
+		if !c.check(&target) {
+			t.Errorf("%s %+v: command not applied, got %+v", c.event, c.cmd, target.Spec)
+		}
+		if other.Spec.Cancel != "" || other.Spec.PromoteTag != "" || len(other.Annotations) != 0 {
+			t.Errorf("%s %+v: command applied to the wrong suite", c.event, c.cmd)
+		}
+	}
+}
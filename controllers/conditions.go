@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
+	"github.com/pluralsh/test-harness/pkg/plural"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func setCondition(suite *testv1alpha1.TestSuite, condition string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&suite.Status.Conditions, metav1.Condition{
+		Type:               condition,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: suite.Generation,
+	})
+}
+
+// syncCompletionConditions derives the Completed and Succeeded conditions from the current suite status
+func syncCompletionConditions(suite *testv1alpha1.TestSuite) {
+	if !suiteCompleted(suite) {
+		setCondition(suite, testv1alpha1.ConditionCompleted, metav1.ConditionFalse, "InProgress", "the test suite is still running")
+		setCondition(suite, testv1alpha1.ConditionSucceeded, metav1.ConditionUnknown, "InProgress", "the test suite is still running")
+		return
+	}
+
+	setCondition(suite, testv1alpha1.ConditionCompleted, metav1.ConditionTrue, "Finished", "the test suite has finished")
+	if suite.Status.Status == plural.StatusSucceeded {
+		setCondition(suite, testv1alpha1.ConditionSucceeded, metav1.ConditionTrue, "Succeeded", "all test steps succeeded")
+		return
+	}
+
+	if suite.Status.Status == plural.StatusCancelled {
+		setCondition(suite, testv1alpha1.ConditionSucceeded, metav1.ConditionFalse, testv1alpha1.ReasonCancelled, "the test suite was cancelled")
+		return
+	}
+
+	reason := "Failed"
+	if suite.Status.Reason != "" {
+		reason = suite.Status.Reason
+	}
+	setCondition(suite, testv1alpha1.ConditionSucceeded, metav1.ConditionFalse, reason, "the test suite failed")
+}
@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	ExecutorArgo   = "argo"
+	ExecutorJobs   = "jobs"
+	ExecutorTekton = "tekton"
+)
+
+// Executor runs the steps of a suite on some workload backend
+type Executor interface {
+	// Object is the kind of resource runs are executed with, which the controller watches for changes
+	Object() client.Object
+
+	// Validate checks the suite can be run by this executor
+	Validate(suite *testv1alpha1.TestSuite) error
+
+	// Create starts the suite's current run, adopting anything an earlier attempt already created, and returns its name
+	Create(ctx context.Context, suite *testv1alpha1.TestSuite) (string, error)
+
+	// Sync updates the suite and step statuses from the current run
+	Sync(ctx context.Context, suite *testv1alpha1.TestSuite) error
+
+	// Suspend pauses or resumes the current run to match spec.suspend
+	Suspend(ctx context.Context, suite *testv1alpha1.TestSuite) error
+
+	// Cancel shuts down the current run using the strategy in spec.cancel
+	Cancel(ctx context.Context, suite *testv1alpha1.TestSuite) error
+
+	// Pods returns the started pods of the current run, keyed by step name
+	Pods(ctx context.Context, suite *testv1alpha1.TestSuite) (map[string][]*corev1.Pod, error)
+}
+
+// NewExecutor builds the executor with the given name
+func NewExecutor(name string, c client.Client, scheme *runtime.Scheme) (Executor, error) {
+	switch name {
+	case ExecutorArgo:
+		return &ArgoExecutor{Client: c, Scheme: scheme}, nil
+	case ExecutorJobs:
+		return &JobExecutor{Client: c, Scheme: scheme}, nil
+	case ExecutorTekton:
+		return &TektonExecutor{Client: c, Scheme: scheme}, nil
+	}
+
+	return nil, fmt.Errorf("unknown executor %s, must be one of %s, %s or %s", name, ExecutorArgo, ExecutorJobs, ExecutorTekton)
+}
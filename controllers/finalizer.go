@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// finalizePluralTimeout bounds how long a deleted suite waits for plural to take its final status, so a plural
+// outage can't hold up deletions indefinitely
+const finalizePluralTimeout = 5 * time.Minute
+
+// finalize stops log tailing for a deleted suite, fails its plural test if it never finished, and removes
+// the rbac the harness created for its namespace if no other suites still need it
+func (r *TestSuiteReconciler) finalize(ctx context.Context, suite *testv1alpha1.TestSuite) error {
+	if !controllerutil.ContainsFinalizer(suite, cleanupFinalizer) {
+		return nil
+	}
+
+	log := r.Log.WithValues("testsuite", types.NamespacedName{Namespace: suite.Namespace, Name: suite.Name})
+	if err := r.LogManager.Cancel(suite); err != nil {
+		log.Info("no log watchers to cancel")
+	}
+
+	if suite.Status.PluralId != "" && !suiteCompleted(suite) {
+		markCancelled(suite)
+		if _, err := r.Plural.UpdateTest(suite.Status.PluralId, suiteToPluralTest(suite)); err != nil {
+			if time.Since(suite.DeletionTimestamp.Time) < finalizePluralTimeout {
+				return err
+			}
+			log.Error(err, "giving up on updating plural test for deleted testsuite", "id", suite.Status.PluralId)
+		}
+	}
+	r.forgetPluralTest(suite.Status.PluralId)
+
+	inUse, err := r.namespaceInUse(ctx, suite)
+	if err != nil {
+		return err
+	}
+
+	if !inUse {
+		if err := r.removeMinimalRole(ctx, suite.Namespace, serviceAccountName); err != nil {
+			return err
+		}
+	}
+
+	controllerutil.RemoveFinalizer(suite, cleanupFinalizer)
+	return r.Update(ctx, suite)
+}
+
+// namespaceInUse checks whether any other live suite in the namespace still relies on the harness' rbac
+func (r *TestSuiteReconciler) namespaceInUse(ctx context.Context, suite *testv1alpha1.TestSuite) (bool, error) {
+	var suites testv1alpha1.TestSuiteList
+	if err := r.List(ctx, &suites, client.InNamespace(suite.Namespace)); err != nil {
+		return false, err
+	}
+
+	for _, other := range suites.Items {
+		if other.Name != suite.Name && other.DeletionTimestamp.IsZero() {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (r *TestSuiteReconciler) removeMinimalRole(ctx context.Context, namespace, sa string) error {
+	// like the service account, only remove bindings the harness created itself
+	var crb rbacv1.ClusterRoleBinding
+	err := r.Get(ctx, types.NamespacedName{Name: minimalRoleName(namespace, sa)}, &crb)
+	if client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	if err == nil && crb.Labels[managedLabel] == "true" {
+		if err := r.Delete(ctx, &crb); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	var serviceaccount corev1.ServiceAccount
+	if err := r.Get(ctx, types.NamespacedName{Name: sa, Namespace: namespace}, &serviceaccount); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	if serviceaccount.Labels[managedLabel] != "true" {
+		return nil
+	}
+
+	return client.IgnoreNotFound(r.Delete(ctx, &serviceaccount))
+}
@@ -0,0 +1,377 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
+	"github.com/pluralsh/test-harness/pkg/plural"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	stepLabel     = "test.plural.sh/step"
+	mainContainer = "main"
+)
+
+// JobExecutor runs each step of a suite as a batch/v1 job, creating them as their dependencies succeed, so
+// suites can run on clusters without argo.  Only container templates are supported.
+type JobExecutor struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+func (e *JobExecutor) Object() client.Object {
+	return &batchv1.Job{}
+}
+
+func (e *JobExecutor) Validate(suite *testv1alpha1.TestSuite) error {
+	if err := suite.ValidateSpec(); err != nil {
+		return err
+	}
+
+	var errs field.ErrorList
+	stepsPath := field.NewPath("spec").Child("steps")
+	for i, step := range suite.Spec.Steps {
+		path := stepsPath.Index(i)
+		if step.Template.Container == nil {
+			errs = append(errs, field.Invalid(path.Child("template"), step.Name, "the jobs executor only supports container templates"))
+		}
+
+		for _, msg := range validation.IsDNS1123Label(jobName(suite, step.Name)) {
+			errs = append(errs, field.Invalid(path.Child("name"), step.Name, msg))
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return apierrors.NewInvalid(testv1alpha1.GroupVersion.WithKind("TestSuite").GroupKind(), suite.Name, errs)
+}
+
+func (e *JobExecutor) Create(ctx context.Context, suite *testv1alpha1.TestSuite) (string, error) {
+	if err := e.Validate(suite); err != nil {
+		return "", err
+	}
+
+	jobs, err := e.jobs(ctx, suite)
+	if err != nil {
+		return "", err
+	}
+
+	if err := e.schedule(ctx, suite, jobs); err != nil {
+		return "", err
+	}
+
+	return workflowName(suite), nil
+}
+
+func (e *JobExecutor) Sync(ctx context.Context, suite *testv1alpha1.TestSuite) error {
+	jobs, err := e.jobs(ctx, suite)
+	if err != nil {
+		return err
+	}
+
+	pods, err := e.pods(ctx, suite)
+	if err != nil {
+		return err
+	}
+
+	for _, status := range suite.Status.Steps {
+		if job, ok := jobs[status.Name]; ok {
+			syncJobStatus(job, status)
+		}
+
+		names := make([]string, 0)
+		for _, pod := range pods[status.Name] {
+			names = append(names, pod.Name)
+		}
+		if len(names) > 0 {
+			status.Pods = names
+			status.Attempts = int32(len(names))
+		}
+	}
+
+	if suiteTimedOut(suite) {
+		if err := e.deleteJobs(ctx, jobs); err != nil {
+			return err
+		}
+
+		suite.Status.Status = plural.StatusFailed
+		suite.Status.Reason = testv1alpha1.ReasonTimedOut
+		for _, status := range suite.Status.Steps {
+			if status.Status == plural.StatusRunning {
+				status.Status = plural.StatusFailed
+				status.Reason = testv1alpha1.ReasonTimedOut
+			}
+		}
+		syncCompletionTime(suite)
+		return nil
+	}
+
+	// cancelled runs never start another step, even when stopped rather than terminated
+	if !suite.Spec.Suspend && suite.Spec.Cancel == "" {
+		if err := e.schedule(ctx, suite, jobs); err != nil {
+			return err
+		}
+	}
+
+	suite.Status.Status = jobSuiteStatus(suite)
+	syncCompletionTime(suite)
+	return nil
+}
+
+func (e *JobExecutor) Suspend(ctx context.Context, suite *testv1alpha1.TestSuite) error {
+	jobs, err := e.jobs(ctx, suite)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		suspended := job.Spec.Suspend != nil && *job.Spec.Suspend
+		if suspended == suite.Spec.Suspend || jobFinished(job) {
+			continue
+		}
+
+		job.Spec.Suspend = &suite.Spec.Suspend
+		if err := e.Update(ctx, job); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Cancel stops scheduling further steps, and with the Terminate strategy also kills any running ones
+func (e *JobExecutor) Cancel(ctx context.Context, suite *testv1alpha1.TestSuite) error {
+	if suite.Spec.Cancel != testv1alpha1.CancelTerminate {
+		return nil
+	}
+
+	jobs, err := e.jobs(ctx, suite)
+	if err != nil {
+		return err
+	}
+
+	return e.deleteJobs(ctx, jobs)
+}
+
+func (e *JobExecutor) Pods(ctx context.Context, suite *testv1alpha1.TestSuite) (map[string][]*corev1.Pod, error) {
+	pods, err := e.pods(ctx, suite)
+	if err != nil {
+		return nil, err
+	}
+
+	return startedPods(pods), nil
+}
+
+// schedule creates the job for every step whose dependencies have all succeeded
+func (e *JobExecutor) schedule(ctx context.Context, suite *testv1alpha1.TestSuite, jobs map[string]*batchv1.Job) error {
+	deps, err := suite.Spec.StepDependencies()
+	if err != nil {
+		return err
+	}
+
+	statuses := stepStatuses(suite)
+	for _, step := range suite.Spec.Steps {
+		if _, ok := jobs[step.Name]; ok {
+			continue
+		}
+
+		ready := true
+		for _, dep := range deps[step.Name] {
+			if status, ok := statuses[dep]; !ok || status.Status != plural.StatusSucceeded {
+				ready = false
+			}
+		}
+		if !ready {
+			continue
+		}
+
+		job := stepToJob(suite, step)
+		if err := controllerutil.SetControllerReference(suite, job, e.Scheme); err != nil {
+			return err
+		}
+
+		if err := e.Client.Create(ctx, job); err != nil && !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+
+		jobs[step.Name] = job
+		if status, ok := statuses[step.Name]; ok && status.Status == plural.StatusQueued {
+			status.Status = plural.StatusRunning
+		}
+		if suite.Status.StartTime == nil {
+			t := metav1.Now()
+			suite.Status.StartTime = &t
+		}
+	}
+
+	return nil
+}
+
+func (e *JobExecutor) jobs(ctx context.Context, suite *testv1alpha1.TestSuite) (map[string]*batchv1.Job, error) {
+	var jobs batchv1.JobList
+	if err := e.List(ctx, &jobs, client.InNamespace(suite.Namespace), runLabels(suite)); err != nil {
+		return nil, err
+	}
+
+	res := map[string]*batchv1.Job{}
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if metav1.IsControlledBy(job, suite) {
+			res[job.Labels[stepLabel]] = job
+		}
+	}
+	return res, nil
+}
+
+func (e *JobExecutor) pods(ctx context.Context, suite *testv1alpha1.TestSuite) (map[string][]*corev1.Pod, error) {
+	return runPods(ctx, e.Client, suite, stepLabel)
+}
+
+func (e *JobExecutor) deleteJobs(ctx context.Context, jobs map[string]*batchv1.Job) error {
+	for _, job := range jobs {
+		if jobFinished(job) {
+			continue
+		}
+
+		if err := e.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func stepToJob(suite *testv1alpha1.TestSuite, step *testv1alpha1.TestStep) *batchv1.Job {
+	tmpl := step.Template
+	labels := runLabels(suite)
+	labels[stepLabel] = step.Name
+
+	podLabels := map[string]string{}
+	for k, v := range tmpl.Metadata.Labels {
+		podLabels[k] = v
+	}
+	for k, v := range labels {
+		podLabels[k] = v
+	}
+
+	container := *tmpl.Container.DeepCopy()
+	if container.Name == "" {
+		container.Name = mainContainer
+	}
+
+	job := &batchv1.Job{}
+	job.Name = jobName(suite, step.Name)
+	job.Namespace = suite.Namespace
+	job.Labels = labels
+	job.Annotations = map[string]string{ownedAnnotation: suite.Name}
+	job.Spec.BackoffLimit = new(int32)
+	if step.Retry != nil {
+		job.Spec.BackoffLimit = &step.Retry.Limit
+	}
+	if step.Timeout != nil {
+		deadline := deadlineSeconds(step.Timeout)
+		job.Spec.ActiveDeadlineSeconds = &deadline
+	}
+	if suite.Spec.Suspend {
+		job.Spec.Suspend = &suite.Spec.Suspend
+	}
+
+	job.Spec.Template.Labels = podLabels
+	job.Spec.Template.Annotations = tmpl.Metadata.Annotations
+	job.Spec.Template.Spec = corev1.PodSpec{
+		Containers:         []corev1.Container{container},
+		RestartPolicy:      corev1.RestartPolicyNever,
+		ServiceAccountName: tmpl.ServiceAccountName,
+		NodeSelector:       tmpl.NodeSelector,
+		Affinity:           tmpl.Affinity,
+		Tolerations:        tmpl.Tolerations,
+		Volumes:            tmpl.Volumes,
+		SecurityContext:    tmpl.SecurityContext,
+		PriorityClassName:  tmpl.PriorityClassName,
+	}
+	return job
+}
+
+func syncJobStatus(job *batchv1.Job, status *testv1alpha1.StepStatus) {
+	for _, cond := range job.Status.Conditions {
+		if cond.Status != corev1.ConditionTrue {
+			continue
+		}
+
+		switch cond.Type {
+		case batchv1.JobComplete:
+			status.Status = plural.StatusSucceeded
+			return
+		case batchv1.JobFailed:
+			status.Status = plural.StatusFailed
+			if cond.Reason == "DeadlineExceeded" {
+				status.Reason = testv1alpha1.ReasonTimedOut
+			}
+			return
+		}
+	}
+
+	status.Status = plural.StatusRunning
+}
+
+// jobSuiteStatus rolls the step statuses up into the suite's.  A failed step fails the suite once nothing
+// else is running, since its dependents will never be scheduled.
+func jobSuiteStatus(suite *testv1alpha1.TestSuite) plural.Status {
+	succeeded, failed, running := 0, 0, 0
+	for _, status := range suite.Status.Steps {
+		switch status.Status {
+		case plural.StatusSucceeded:
+			succeeded++
+		case plural.StatusFailed:
+			failed++
+		case plural.StatusRunning:
+			running++
+		}
+	}
+
+	switch {
+	case running > 0:
+		return plural.StatusRunning
+	case failed > 0:
+		return plural.StatusFailed
+	case succeeded == len(suite.Status.Steps):
+		return plural.StatusSucceeded
+	case succeeded > 0:
+		return plural.StatusRunning
+	}
+	return plural.StatusQueued
+}
+
+func jobFinished(job *batchv1.Job) bool {
+	for _, cond := range job.Status.Conditions {
+		if (cond.Type == batchv1.JobComplete || cond.Type == batchv1.JobFailed) && cond.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+func jobName(suite *testv1alpha1.TestSuite, step string) string {
+	return fmt.Sprintf("%s-%s", workflowName(suite), step)
+}
+
+// suiteTimedOut checks whether an unfinished suite has run past spec.timeout
+func suiteTimedOut(suite *testv1alpha1.TestSuite) bool {
+	if suite.Spec.Timeout == nil || suite.Status.StartTime == nil || suiteCompleted(suite) {
+		return false
+	}
+
+	return time.Since(suite.Status.StartTime.Time) >= suite.Spec.Timeout.Duration
+}
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	argov1alpha1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
+	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
+	"github.com/pluralsh/test-harness/pkg/plural"
+	"github.com/pluralsh/test-harness/pkg/utils"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSyncJobStatus(t *testing.T) {
+	cond := func(typ batchv1.JobConditionType, status corev1.ConditionStatus, reason string) batchv1.JobCondition {
+		return batchv1.JobCondition{Type: typ, Status: status, Reason: reason}
+	}
+
+	cases := []struct {
+		name       string
+		conditions []batchv1.JobCondition
+		status     plural.Status
+		reason     string
+	}{
+		{"no conditions", nil, plural.StatusRunning, ""},
+		{"complete", []batchv1.JobCondition{cond(batchv1.JobComplete, corev1.ConditionTrue, "")}, plural.StatusSucceeded, ""},
+		{"out of retries", []batchv1.JobCondition{cond(batchv1.JobFailed, corev1.ConditionTrue, "BackoffLimitExceeded")}, plural.StatusFailed, ""},
+		{"past its deadline", []batchv1.JobCondition{cond(batchv1.JobFailed, corev1.ConditionTrue, "DeadlineExceeded")}, plural.StatusFailed, testv1alpha1.ReasonTimedOut},
+		{"unset condition", []batchv1.JobCondition{cond(batchv1.JobFailed, corev1.ConditionFalse, "")}, plural.StatusRunning, ""},
+	}
+
+	for _, c := range cases {
+		status := &testv1alpha1.StepStatus{Name: "step", Status: plural.StatusQueued}
+		syncJobStatus(&batchv1.Job{Status: batchv1.JobStatus{Conditions: c.conditions}}, status)
+		if status.Status != c.status || status.Reason != c.reason {
+			t.Errorf("%s: expected %s %q, got %s %q", c.name, c.status, c.reason, status.Status, status.Reason)
+		}
+	}
+}
+
+func TestJobSuiteStatus(t *testing.T) {
+	cases := []struct {
+		name     string
+		steps    []plural.Status
+		expected plural.Status
+	}{
+		{"nothing started", []plural.Status{plural.StatusQueued, plural.StatusQueued}, plural.StatusQueued},
+		{"a step running", []plural.Status{plural.StatusRunning, plural.StatusQueued}, plural.StatusRunning},
+		{"between steps", []plural.Status{plural.StatusSucceeded, plural.StatusQueued}, plural.StatusRunning},
+		{"every step succeeded", []plural.Status{plural.StatusSucceeded, plural.StatusSucceeded}, plural.StatusSucceeded},
+		{"a step failed", []plural.Status{plural.StatusSucceeded, plural.StatusFailed, plural.StatusQueued}, plural.StatusFailed},
+		{"a step failed while another runs", []plural.Status{plural.StatusFailed, plural.StatusRunning}, plural.StatusRunning},
+	}
+
+	for _, c := range cases {
+		suite := &testv1alpha1.TestSuite{}
+		for i, status := range c.steps {
+			suite.Status.Steps = append(suite.Status.Steps, &testv1alpha1.StepStatus{Name: string(rune('a' + i)), Status: status})
+		}
+		if status := jobSuiteStatus(suite); status != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.name, c.expected, status)
+		}
+	}
+}
+
+func TestStepToJobRetries(t *testing.T) {
+	suite := &testv1alpha1.TestSuite{ObjectMeta: metav1.ObjectMeta{Name: "suite", Namespace: "default"}}
+	container := &argov1alpha1.Template{Container: &corev1.Container{Image: "busybox"}}
+
+	cases := []struct {
+		name     string
+		step     *testv1alpha1.TestStep
+		backoff  int32
+		deadline *int64
+	}{
+		{"runs once by default", &testv1alpha1.TestStep{Name: "first", Template: container}, 0, nil},
+		{"retries up to the limit", &testv1alpha1.TestStep{Name: "first", Template: container, Retry: &testv1alpha1.RetryPolicy{Limit: 3}}, 3, nil},
+		{"bounds every attempt by the timeout", &testv1alpha1.TestStep{Name: "first", Template: container, Retry: &testv1alpha1.RetryPolicy{Limit: 3}, Timeout: &metav1.Duration{Duration: 90 * time.Second}}, 3, utils.Int64(90)},
+	}
+
+	for _, c := range cases {
+		job := stepToJob(suite, c.step)
+		if *job.Spec.BackoffLimit != c.backoff {
+			t.Errorf("%s: expected a backoff limit of %d, got %d", c.name, c.backoff, *job.Spec.BackoffLimit)
+		}
+		if (job.Spec.ActiveDeadlineSeconds == nil) != (c.deadline == nil) || (c.deadline != nil && *job.Spec.ActiveDeadlineSeconds != *c.deadline) {
+			t.Errorf("%s: expected a deadline of %v, got %v", c.name, c.deadline, job.Spec.ActiveDeadlineSeconds)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// syncPluralTest pushes the suite's current state to plural, skipping the call if nothing changed since the last
+// successful push.  The outcome is recorded in the PluralSynced condition.
+func (r *TestSuiteReconciler) syncPluralTest(suite *testv1alpha1.TestSuite) error {
+	attrs := suiteToPluralTest(suite)
+	body, err := json.Marshal(attrs)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+	if last, ok := r.pushed.Load(suite.Status.PluralId); ok && last == hash {
+		return nil
+	}
+
+	if _, err := r.Plural.UpdateTest(suite.Status.PluralId, attrs); err != nil {
+		setCondition(suite, testv1alpha1.ConditionPluralSynced, metav1.ConditionFalse, "SyncFailed", err.Error())
+		return err
+	}
+
+	r.pushed.Store(suite.Status.PluralId, hash)
+	setCondition(suite, testv1alpha1.ConditionPluralSynced, metav1.ConditionTrue, "Synced", "plural has the latest test status")
+	return nil
+}
+
+// pluralSynced checks whether the last push of the suite's state to plural went through
+func pluralSynced(suite *testv1alpha1.TestSuite) bool {
+	cond := meta.FindStatusCondition(suite.Status.Conditions, testv1alpha1.ConditionPluralSynced)
+	return cond == nil || cond.Status != metav1.ConditionFalse
+}
+
+// forgetPluralTest drops the record of the last push for a test that's no longer managed
+func (r *TestSuiteReconciler) forgetPluralTest(id string) {
+	r.pushed.Delete(id)
+}
@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
+	"github.com/pluralsh/test-harness/pkg/logs"
+	"github.com/pluralsh/test-harness/pkg/plural"
+	"github.com/pluralsh/test-harness/pkg/plural/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSyncPluralTestSkipsUnchanged(t *testing.T) {
+	client := fake.NewClient()
+	r := &TestSuiteReconciler{Plural: client}
+
+	test, err := client.CreateTest("repo", suiteToPluralTest(&testv1alpha1.TestSuite{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suite := &testv1alpha1.TestSuite{
+		ObjectMeta: metav1.ObjectMeta{Name: "suite"},
+		Spec:       testv1alpha1.TestSuiteSpec{Steps: []*testv1alpha1.TestStep{{Name: "first"}}},
+		Status: testv1alpha1.TestSuiteStatus{
+			PluralId: test.Id,
+			Status:   plural.StatusRunning,
+			Steps:    []*testv1alpha1.StepStatus{{Name: "first", Status: plural.StatusRunning}},
+		},
+	}
+
+	updates := func() int { return len(client.Calls(plural.MethodUpdateTest)) }
+	for i := 0; i < 3; i++ {
+		if err := r.syncPluralTest(suite); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := updates(); n != 1 {
+		t.Errorf("expected unchanged updates to be skipped, got %d", n)
+	}
+
+	suite.Status.Steps[0].Status = plural.StatusSucceeded
+	if err := r.syncPluralTest(suite); err != nil {
+		t.Fatal(err)
+	}
+	if n := updates(); n != 2 {
+		t.Errorf("expected changes to be pushed, got %d updates", n)
+	}
+
+	// failed pushes aren't remembered, so the same state is sent again
+	suite.Status.Status = plural.StatusSucceeded
+	client.Fail(plural.MethodUpdateTest, errors.New("unavailable"))
+	if err := r.syncPluralTest(suite); err == nil {
+		t.Fatal("expected the push to fail")
+	}
+	client.Fail(plural.MethodUpdateTest, nil)
+	if err := r.syncPluralTest(suite); err != nil {
+		t.Fatal(err)
+	}
+	if n := updates(); n != 4 {
+		t.Errorf("expected the failed push to be retried, got %d updates", n)
+	}
+
+	// rerun suites are forgotten, so their next push always goes through
+	r.forgetPluralTest(test.Id)
+	if err := r.syncPluralTest(suite); err != nil {
+		t.Fatal(err)
+	}
+	if n := updates(); n != 5 {
+		t.Errorf("expected forgotten tests to be pushed again, got %d updates", n)
+	}
+}
+
+// stubExecutor leaves a suite's status alone, as if its run were still going
+type stubExecutor struct{}
+
+func (stubExecutor) Object() client.Object                        { return &corev1.Pod{} }
+func (stubExecutor) Validate(suite *testv1alpha1.TestSuite) error { return nil }
+func (stubExecutor) Create(ctx context.Context, suite *testv1alpha1.TestSuite) (string, error) {
+	return suite.Status.WorkflowName, nil
+}
+func (stubExecutor) Sync(ctx context.Context, suite *testv1alpha1.TestSuite) error    { return nil }
+func (stubExecutor) Suspend(ctx context.Context, suite *testv1alpha1.TestSuite) error { return nil }
+func (stubExecutor) Cancel(ctx context.Context, suite *testv1alpha1.TestSuite) error  { return nil }
+func (stubExecutor) Pods(ctx context.Context, suite *testv1alpha1.TestSuite) (map[string][]*corev1.Pod, error) {
+	return nil, nil
+}
+
+func TestReconcileRetriesCancelledPush(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := testv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	plrl := fake.NewClient()
+	test, err := plrl.CreateTest("repo", suiteToPluralTest(&testv1alpha1.TestSuite{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	suite := &testv1alpha1.TestSuite{
+		ObjectMeta: metav1.ObjectMeta{Name: "cancel", Namespace: "default", Finalizers: []string{cleanupFinalizer}},
+		Spec: testv1alpha1.TestSuiteSpec{
+			Cancel: testv1alpha1.CancelTerminate,
+			Steps:  []*testv1alpha1.TestStep{{Name: "only"}},
+		},
+		Status: testv1alpha1.TestSuiteStatus{
+			PluralId:     test.Id,
+			WorkflowName: "cancel-1",
+			Status:       plural.StatusRunning,
+			StartTime:    &metav1.Time{Time: time.Now()},
+			Steps:        []*testv1alpha1.StepStatus{{Name: "only", Status: plural.StatusRunning}},
+		},
+	}
+	kube := crfake.NewClientBuilder().WithScheme(scheme).WithObjects(suite).Build()
+	r := &TestSuiteReconciler{
+		Client:     kube,
+		Scheme:     scheme,
+		Plural:     plrl,
+		LogManager: &logs.LogManager{Suites: map[string]*logs.SuiteManager{}},
+		SuiteTTL:   DefaultSuiteTTL,
+		Executor:   stubExecutor{},
+		Log:        ctrl.Log,
+	}
+
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(suite)}
+	plrl.Fail(plural.MethodUpdateTest, errors.New("unavailable"))
+	if _, err := r.Reconcile(ctx, req); err == nil {
+		t.Fatal("expected the failed push to requeue the suite")
+	}
+
+	var found testv1alpha1.TestSuite
+	if err := kube.Get(ctx, req.NamespacedName, &found); err != nil {
+		t.Fatal(err)
+	}
+	if found.Status.Status != plural.StatusCancelled || pluralSynced(&found) {
+		t.Fatalf("expected a cancelled suite waiting on plural, got %s with conditions %v", found.Status.Status, found.Status.Conditions)
+	}
+
+	// the requeue finds the suite already cancelled, but plural still has to be told
+	plrl.Fail(plural.MethodUpdateTest, nil)
+	failed := len(plrl.Calls(plural.MethodUpdateTest))
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	// plural has no cancelled status, so cancellations show up as failures
+	pushed, _ := plrl.Test(test.Id)
+	if n := len(plrl.Calls(plural.MethodUpdateTest)); n != failed+1 || pushed.Status != plural.StatusFailed {
+		t.Errorf("expected plural to have the cancellation, got %d pushes and status %s", n-failed, pushed.Status)
+	}
+	if err := kube.Get(ctx, req.NamespacedName, &found); err != nil {
+		t.Fatal(err)
+	}
+	if !pluralSynced(&found) {
+		t.Errorf("expected the suite to be marked synced, got %v", found.Status.Conditions)
+	}
+
+	// once plural has it, later passes leave it alone
+	updates := len(plrl.Calls(plural.MethodUpdateTest))
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(plrl.Calls(plural.MethodUpdateTest)); n != updates {
+		t.Errorf("expected no further pushes, got %d", n-updates)
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package controllers
 
 import (
+	"context"
 	"path/filepath"
 	"testing"
 
@@ -24,6 +25,7 @@ import (
 	. "github.com/onsi/gomega"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	"sigs.k8s.io/controller-runtime/pkg/envtest/printer"
@@ -31,6 +33,9 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
+	"github.com/pluralsh/test-harness/pkg/logs"
+	"github.com/pluralsh/test-harness/pkg/plural"
+	"github.com/pluralsh/test-harness/pkg/plural/fake"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -40,6 +45,9 @@ import (
 var cfg *rest.Config
 var k8sClient client.Client
 var testEnv *envtest.Environment
+var plrlServer *fake.Server
+var commands *CommandListener
+var cancel context.CancelFunc
 
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
@@ -71,10 +79,47 @@ var _ = BeforeSuite(func() {
 	Expect(err).NotTo(HaveOccurred())
 	Expect(k8sClient).NotTo(BeNil())
 
+	// envtest has no argo installed, so suites are run as plain jobs against a local stand-in for the plural api.
+	// Otherwise the manager reads through the same caches as main's.
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:                scheme.Scheme,
+		MetricsBindAddress:    "0",
+		ClientDisableCacheFor: UncachedObjects(),
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	plrlServer = fake.NewServer("test-token")
+	logManager := logs.NewManager(plrlServer.Config())
+	commands = &CommandListener{Client: mgr.GetClient(), Socket: logManager.Socket, Log: ctrl.Log.WithName("commands")}
+	Expect(commands.SetupWithManager(mgr)).To(Succeed())
+	err = (&TestSuiteReconciler{
+		Client:     mgr.GetClient(),
+		Scheme:     mgr.GetScheme(),
+		Plural:     plural.NewClient(plrlServer.Config()),
+		LogManager: logManager,
+		SuiteTTL:   DefaultSuiteTTL,
+		Executor:   &JobExecutor{Client: mgr.GetClient(), Scheme: mgr.GetScheme()},
+		Commands:   commands,
+		Log:        ctrl.Log.WithName("controllers").WithName("TestSuite"),
+	}).SetupWithManager(mgr)
+	Expect(err).NotTo(HaveOccurred())
+
+	var ctx context.Context
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		defer GinkgoRecover()
+		Expect(mgr.Start(ctx)).To(Succeed())
+	}()
 }, 60)
 
 var _ = AfterSuite(func() {
 	By("tearing down the test environment")
+	if cancel != nil {
+		cancel()
+	}
+	if plrlServer != nil {
+		plrlServer.Close()
+	}
 	err := testEnv.Stop()
 	Expect(err).NotTo(HaveOccurred())
 })
@@ -0,0 +1,449 @@
+package controllers
+
+import (
+	"context"
+
+	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
+	"github.com/pluralsh/test-harness/pkg/plural"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	pipelineRunLabel  = "tekton.dev/pipelineRun"
+	pipelineTaskLabel = "tekton.dev/pipelineTask"
+
+	// pipelinerun spec.status values
+	pipelineRunPending        = "PipelineRunPending"
+	pipelineRunCancelled      = "Cancelled"
+	pipelineRunStoppedFinally = "StoppedRunFinally"
+)
+
+var (
+	pipelineRunGVK = schema.GroupVersionKind{Group: "tekton.dev", Version: "v1beta1", Kind: "PipelineRun"}
+	taskRunListGVK = schema.GroupVersionKind{Group: "tekton.dev", Version: "v1beta1", Kind: "TaskRunList"}
+)
+
+// TektonExecutor runs each suite as a tekton PipelineRun with an embedded task per step.  Tekton's go types
+// aren't a dependency of the harness, so runs are handled as unstructured objects decoded into the handful
+// of fields below.  Only container and script templates are supported.
+type TektonExecutor struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+type pipelineRun struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              pipelineRunSpec `json:"spec"`
+	Status            tektonRunStatus `json:"status,omitempty"`
+}
+
+type pipelineRunSpec struct {
+	PipelineSpec pipelineSpec      `json:"pipelineSpec"`
+	Timeouts     *pipelineTimeouts `json:"timeouts,omitempty"`
+	TaskRunSpecs []taskRunSpec     `json:"taskRunSpecs,omitempty"`
+	Status       string            `json:"status,omitempty"`
+}
+
+type pipelineTimeouts struct {
+	Pipeline *metav1.Duration `json:"pipeline,omitempty"`
+}
+
+type pipelineSpec struct {
+	Tasks []pipelineTask `json:"tasks"`
+}
+
+type pipelineTask struct {
+	Name     string           `json:"name"`
+	RunAfter []string         `json:"runAfter,omitempty"`
+	Retries  int32            `json:"retries,omitempty"`
+	Timeout  *metav1.Duration `json:"timeout,omitempty"`
+	TaskSpec embeddedTask     `json:"taskSpec"`
+}
+
+type embeddedTask struct {
+	Metadata taskMetadata    `json:"metadata,omitempty"`
+	Steps    []tektonStep    `json:"steps"`
+	Volumes  []corev1.Volume `json:"volumes,omitempty"`
+}
+
+type taskMetadata struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type tektonStep struct {
+	corev1.Container `json:",inline"`
+	Script           string `json:"script,omitempty"`
+}
+
+type taskRunSpec struct {
+	PipelineTaskName       string             `json:"pipelineTaskName"`
+	TaskServiceAccountName string             `json:"taskServiceAccountName,omitempty"`
+	TaskPodTemplate        *tektonPodTemplate `json:"taskPodTemplate,omitempty"`
+}
+
+type tektonPodTemplate struct {
+	NodeSelector      map[string]string          `json:"nodeSelector,omitempty"`
+	Tolerations       []corev1.Toleration        `json:"tolerations,omitempty"`
+	Affinity          *corev1.Affinity           `json:"affinity,omitempty"`
+	SecurityContext   *corev1.PodSecurityContext `json:"securityContext,omitempty"`
+	PriorityClassName *string                    `json:"priorityClassName,omitempty"`
+}
+
+type tektonRunStatus struct {
+	Conditions []tektonCondition `json:"conditions,omitempty"`
+	StartTime  *metav1.Time      `json:"startTime,omitempty"`
+}
+
+type tektonCondition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type taskRun struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Status            tektonRunStatus `json:"status,omitempty"`
+}
+
+func (e *TektonExecutor) Object() client.Object {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(pipelineRunGVK)
+	return obj
+}
+
+func (e *TektonExecutor) Validate(suite *testv1alpha1.TestSuite) error {
+	if err := suite.ValidateSpec(); err != nil {
+		return err
+	}
+
+	var errs field.ErrorList
+	stepsPath := field.NewPath("spec").Child("steps")
+	for i, step := range suite.Spec.Steps {
+		path := stepsPath.Index(i)
+		if step.Template.Container == nil && step.Template.Script == nil {
+			errs = append(errs, field.Invalid(path.Child("template"), step.Name, "the tekton executor only supports container and script templates"))
+		}
+		// tekton restarts a pipeline task's timeout on every retry, so it can't bound the step as a whole
+		if step.Retry != nil && step.Timeout != nil {
+			errs = append(errs, field.Invalid(path.Child("timeout"), step.Timeout.Duration.String(), "the tekton executor doesn't support timeouts on steps that retry"))
+		}
+
+		for _, msg := range validation.IsDNS1123Label(step.Name) {
+			errs = append(errs, field.Invalid(path.Child("name"), step.Name, msg))
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return apierrors.NewInvalid(testv1alpha1.GroupVersion.WithKind("TestSuite").GroupKind(), suite.Name, errs)
+}
+
+func (e *TektonExecutor) Create(ctx context.Context, suite *testv1alpha1.TestSuite) (string, error) {
+	if err := e.Validate(suite); err != nil {
+		return "", err
+	}
+
+	obj, err := suiteToPipelineRun(suite)
+	if err != nil {
+		return "", err
+	}
+
+	if err := controllerutil.SetControllerReference(suite, obj, e.Scheme); err != nil {
+		return "", err
+	}
+
+	existing := e.Object()
+	err = e.Get(ctx, types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}, existing)
+	if err == nil && metav1.IsControlledBy(existing, suite) {
+		return existing.GetName(), nil
+	}
+
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", err
+	}
+
+	if err := e.Client.Create(ctx, obj); err != nil {
+		return "", err
+	}
+
+	return obj.GetName(), nil
+}
+
+func (e *TektonExecutor) Sync(ctx context.Context, suite *testv1alpha1.TestSuite) error {
+	_, run, err := e.pipelineRun(ctx, suite)
+	if err != nil {
+		return err
+	}
+
+	status, reason := tektonStatus(run.Status.Conditions)
+	suite.Status.Status = status
+	if reason != "" {
+		suite.Status.Reason = reason
+	}
+	if suite.Status.StartTime == nil && run.Status.StartTime != nil {
+		suite.Status.StartTime = run.Status.StartTime.DeepCopy()
+	}
+
+	taskRuns, err := e.taskRuns(ctx, run)
+	if err != nil {
+		return err
+	}
+
+	pods, err := runPods(ctx, e.Client, suite, pipelineTaskLabel)
+	if err != nil {
+		return err
+	}
+
+	for _, step := range suite.Status.Steps {
+		if tr, ok := taskRuns[step.Name]; ok {
+			step.Status, reason = tektonStatus(tr.Status.Conditions)
+			if reason != "" {
+				step.Reason = reason
+			}
+		}
+
+		names := make([]string, 0)
+		for _, pod := range pods[step.Name] {
+			names = append(names, pod.Name)
+		}
+		if len(names) > 0 {
+			step.Pods = names
+			step.Attempts = int32(len(names))
+		}
+	}
+
+	syncCompletionTime(suite)
+	return nil
+}
+
+// Suspend holds back a pipelinerun that hasn't started yet, tekton has no way of pausing one that's running
+func (e *TektonExecutor) Suspend(ctx context.Context, suite *testv1alpha1.TestSuite) error {
+	obj, run, err := e.pipelineRun(ctx, suite)
+	if err != nil {
+		return err
+	}
+
+	if run.Status.StartTime != nil || (run.Spec.Status != "" && run.Spec.Status != pipelineRunPending) {
+		return nil
+	}
+
+	if suspended := run.Spec.Status == pipelineRunPending; suspended == suite.Spec.Suspend {
+		return nil
+	}
+
+	if suite.Spec.Suspend {
+		if err := unstructured.SetNestedField(obj.Object, pipelineRunPending, "spec", "status"); err != nil {
+			return err
+		}
+	} else {
+		unstructured.RemoveNestedField(obj.Object, "spec", "status")
+	}
+
+	return e.Update(ctx, obj)
+}
+
+// Cancel stops the pipelinerun from scheduling further tasks, and with the Terminate strategy also cancels the
+// running ones
+func (e *TektonExecutor) Cancel(ctx context.Context, suite *testv1alpha1.TestSuite) error {
+	obj, run, err := e.pipelineRun(ctx, suite)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	if status, _ := tektonStatus(run.Status.Conditions); status == plural.StatusSucceeded || status == plural.StatusFailed {
+		return nil
+	}
+
+	strategy := pipelineRunStoppedFinally
+	if suite.Spec.Cancel == testv1alpha1.CancelTerminate {
+		strategy = pipelineRunCancelled
+	}
+
+	if run.Spec.Status == strategy {
+		return nil
+	}
+
+	if err := unstructured.SetNestedField(obj.Object, strategy, "spec", "status"); err != nil {
+		return err
+	}
+	return e.Update(ctx, obj)
+}
+
+func (e *TektonExecutor) Pods(ctx context.Context, suite *testv1alpha1.TestSuite) (map[string][]*corev1.Pod, error) {
+	pods, err := runPods(ctx, e.Client, suite, pipelineTaskLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	return startedPods(pods), nil
+}
+
+func (e *TektonExecutor) pipelineRun(ctx context.Context, suite *testv1alpha1.TestSuite) (*unstructured.Unstructured, *pipelineRun, error) {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(pipelineRunGVK)
+	if err := e.Get(ctx, types.NamespacedName{Namespace: suite.Namespace, Name: suite.Status.WorkflowName}, obj); err != nil {
+		return nil, nil, err
+	}
+
+	var run pipelineRun
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &run); err != nil {
+		return nil, nil, err
+	}
+	return obj, &run, nil
+}
+
+// taskRuns returns the taskruns of a pipelinerun keyed by pipeline task, which is the step name
+func (e *TektonExecutor) taskRuns(ctx context.Context, run *pipelineRun) (map[string]*taskRun, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(taskRunListGVK)
+	if err := e.List(ctx, list, client.InNamespace(run.Namespace), client.MatchingLabels{pipelineRunLabel: run.Name}); err != nil {
+		return nil, err
+	}
+
+	res := map[string]*taskRun{}
+	for i := range list.Items {
+		item := &list.Items[i]
+		if !metav1.IsControlledBy(item, run) {
+			continue
+		}
+
+		var tr taskRun
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &tr); err != nil {
+			return nil, err
+		}
+		res[tr.Labels[pipelineTaskLabel]] = &tr
+	}
+	return res, nil
+}
+
+func suiteToPipelineRun(suite *testv1alpha1.TestSuite) (*unstructured.Unstructured, error) {
+	deps, err := suite.Spec.StepDependencies()
+	if err != nil {
+		return nil, err
+	}
+
+	run := pipelineRun{}
+	run.SetGroupVersionKind(pipelineRunGVK)
+	run.Name = workflowName(suite)
+	run.Namespace = suite.Namespace
+	run.Annotations = map[string]string{ownedAnnotation: suite.Name}
+	run.Labels = runLabels(suite)
+	if suite.Spec.Timeout != nil {
+		run.Spec.Timeouts = &pipelineTimeouts{Pipeline: suite.Spec.Timeout}
+	}
+	if suite.Spec.Suspend {
+		run.Spec.Status = pipelineRunPending
+	}
+
+	for _, step := range suite.Spec.Steps {
+		task, spec := stepToPipelineTask(suite, step)
+		task.RunAfter = deps[step.Name]
+		run.Spec.PipelineSpec.Tasks = append(run.Spec.PipelineSpec.Tasks, task)
+		if spec != nil {
+			run.Spec.TaskRunSpecs = append(run.Spec.TaskRunSpecs, *spec)
+		}
+	}
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&run)
+	if err != nil {
+		return nil, err
+	}
+
+	// status is only ever written by tekton
+	delete(obj, "status")
+	return &unstructured.Unstructured{Object: obj}, nil
+}
+
+func stepToPipelineTask(suite *testv1alpha1.TestSuite, step *testv1alpha1.TestStep) (pipelineTask, *taskRunSpec) {
+	tmpl := step.Template
+	task := pipelineTask{Name: step.Name, Timeout: step.Timeout}
+	if step.Retry != nil {
+		task.Retries = step.Retry.Limit
+	}
+
+	labels := map[string]string{}
+	for k, v := range tmpl.Metadata.Labels {
+		labels[k] = v
+	}
+	for k, v := range runLabels(suite) {
+		labels[k] = v
+	}
+	labels[stepLabel] = step.Name
+	task.TaskSpec.Metadata = taskMetadata{Labels: labels, Annotations: tmpl.Metadata.Annotations}
+	task.TaskSpec.Volumes = tmpl.Volumes
+
+	var ts tektonStep
+	if tmpl.Script != nil {
+		ts.Container = *tmpl.Script.Container.DeepCopy()
+		ts.Script = tmpl.Script.Source
+	} else {
+		ts.Container = *tmpl.Container.DeepCopy()
+	}
+	if ts.Name == "" {
+		ts.Name = mainContainer
+	}
+	task.TaskSpec.Steps = []tektonStep{ts}
+
+	if tmpl.ServiceAccountName == "" && tmpl.NodeSelector == nil && tmpl.Affinity == nil &&
+		tmpl.Tolerations == nil && tmpl.SecurityContext == nil && tmpl.PriorityClassName == "" {
+		return task, nil
+	}
+
+	spec := &taskRunSpec{
+		PipelineTaskName:       step.Name,
+		TaskServiceAccountName: tmpl.ServiceAccountName,
+		TaskPodTemplate: &tektonPodTemplate{
+			NodeSelector:    tmpl.NodeSelector,
+			Tolerations:     tmpl.Tolerations,
+			Affinity:        tmpl.Affinity,
+			SecurityContext: tmpl.SecurityContext,
+		},
+	}
+	if tmpl.PriorityClassName != "" {
+		spec.TaskPodTemplate.PriorityClassName = &tmpl.PriorityClassName
+	}
+	return task, spec
+}
+
+// tektonStatus maps the Succeeded condition of a pipelinerun or taskrun onto a plural status and, for runs that
+// hit their timeout, a reason
+func tektonStatus(conditions []tektonCondition) (plural.Status, string) {
+	for _, cond := range conditions {
+		if cond.Type != "Succeeded" {
+			continue
+		}
+
+		switch cond.Status {
+		case "True":
+			return plural.StatusSucceeded, ""
+		case "False":
+			if cond.Reason == "PipelineRunTimeout" || cond.Reason == "TaskRunTimeout" {
+				return plural.StatusFailed, testv1alpha1.ReasonTimedOut
+			}
+			return plural.StatusFailed, ""
+		}
+
+		if cond.Reason == pipelineRunPending || cond.Reason == "Pending" {
+			return plural.StatusQueued, ""
+		}
+		return plural.StatusRunning, ""
+	}
+
+	return plural.StatusQueued, ""
+}
@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	argov1alpha1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
+	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
+	"github.com/pluralsh/test-harness/pkg/plural"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTektonValidate(t *testing.T) {
+	container := &argov1alpha1.Template{Container: &corev1.Container{Image: "busybox"}}
+	retry := &testv1alpha1.RetryPolicy{Limit: 2}
+	timeout := &metav1.Duration{Duration: time.Minute}
+
+	cases := []struct {
+		name  string
+		step  *testv1alpha1.TestStep
+		valid bool
+	}{
+		{"container step", &testv1alpha1.TestStep{Name: "first", Template: container}, true},
+		{"retries", &testv1alpha1.TestStep{Name: "first", Template: container, Retry: retry}, true},
+		{"timeout", &testv1alpha1.TestStep{Name: "first", Template: container, Timeout: timeout}, true},
+		{"timeout across retries", &testv1alpha1.TestStep{Name: "first", Template: container, Retry: retry, Timeout: timeout}, false},
+		{"dag template", &testv1alpha1.TestStep{Name: "first", Template: &argov1alpha1.Template{DAG: &argov1alpha1.DAGTemplate{}}}, false},
+		{"invalid name", &testv1alpha1.TestStep{Name: "First_Step", Template: container}, false},
+	}
+
+	for _, c := range cases {
+		suite := &testv1alpha1.TestSuite{
+			ObjectMeta: metav1.ObjectMeta{Name: "suite", Namespace: "default"},
+			Spec:       testv1alpha1.TestSuiteSpec{Repository: "airbyte", Steps: []*testv1alpha1.TestStep{c.step}},
+		}
+		if err := (&TektonExecutor{}).Validate(suite); (err == nil) != c.valid {
+			t.Errorf("%s: expected valid to be %v, got %v", c.name, c.valid, err)
+		}
+	}
+}
+
+func TestTektonStatus(t *testing.T) {
+	succeeded := func(status, reason string) []tektonCondition {
+		return []tektonCondition{{Type: "Succeeded", Status: status, Reason: reason}}
+	}
+
+	cases := []struct {
+		name       string
+		conditions []tektonCondition
+		status     plural.Status
+		reason     string
+	}{
+		{"no conditions", nil, plural.StatusQueued, ""},
+		{"pending pipelinerun", succeeded("Unknown", pipelineRunPending), plural.StatusQueued, ""},
+		{"pending taskrun", succeeded("Unknown", "Pending"), plural.StatusQueued, ""},
+		{"running", succeeded("Unknown", "Running"), plural.StatusRunning, ""},
+		{"succeeded", succeeded("True", "Succeeded"), plural.StatusSucceeded, ""},
+		{"failed", succeeded("False", "Failed"), plural.StatusFailed, ""},
+		{"pipelinerun timed out", succeeded("False", "PipelineRunTimeout"), plural.StatusFailed, testv1alpha1.ReasonTimedOut},
+		{"taskrun timed out", succeeded("False", "TaskRunTimeout"), plural.StatusFailed, testv1alpha1.ReasonTimedOut},
+		{"other conditions", []tektonCondition{{Type: "Ready", Status: "True"}}, plural.StatusQueued, ""},
+	}
+
+	for _, c := range cases {
+		if status, reason := tektonStatus(c.conditions); status != c.status || reason != c.reason {
+			t.Errorf("%s: expected %s %q, got %s %q", c.name, c.status, c.reason, status, reason)
+		}
+	}
+}
+
+func TestStepToPipelineTask(t *testing.T) {
+	suite := &testv1alpha1.TestSuite{ObjectMeta: metav1.ObjectMeta{Name: "suite", Namespace: "default"}}
+	container := &argov1alpha1.Template{Container: &corev1.Container{Image: "busybox"}}
+	script := &argov1alpha1.Template{Script: &argov1alpha1.ScriptTemplate{Container: corev1.Container{Name: "run", Image: "alpine"}, Source: "echo hi"}}
+	scheduled := &argov1alpha1.Template{
+		Container:          &corev1.Container{Image: "busybox"},
+		ServiceAccountName: "runner",
+		NodeSelector:       map[string]string{"pool": "tests"},
+		PriorityClassName:  "low",
+	}
+	timeout := &metav1.Duration{Duration: time.Minute}
+	labels := map[string]string{suiteLabel: "suite", runLabel: "0", stepLabel: "first"}
+	low := "low"
+
+	cases := []struct {
+		name     string
+		step     *testv1alpha1.TestStep
+		expected pipelineTask
+		spec     *taskRunSpec
+	}{
+		{
+			"container step",
+			&testv1alpha1.TestStep{Name: "first", Template: container},
+			pipelineTask{Name: "first", TaskSpec: embeddedTask{
+				Metadata: taskMetadata{Labels: labels},
+				Steps:    []tektonStep{{Container: corev1.Container{Name: mainContainer, Image: "busybox"}}},
+			}},
+			nil,
+		},
+		{
+			"retries",
+			&testv1alpha1.TestStep{Name: "first", Template: container, Retry: &testv1alpha1.RetryPolicy{Limit: 2}},
+			pipelineTask{Name: "first", Retries: 2, TaskSpec: embeddedTask{
+				Metadata: taskMetadata{Labels: labels},
+				Steps:    []tektonStep{{Container: corev1.Container{Name: mainContainer, Image: "busybox"}}},
+			}},
+			nil,
+		},
+		{
+			"timeout",
+			&testv1alpha1.TestStep{Name: "first", Template: container, Timeout: timeout},
+			pipelineTask{Name: "first", Timeout: timeout, TaskSpec: embeddedTask{
+				Metadata: taskMetadata{Labels: labels},
+				Steps:    []tektonStep{{Container: corev1.Container{Name: mainContainer, Image: "busybox"}}},
+			}},
+			nil,
+		},
+		{
+			"script step",
+			&testv1alpha1.TestStep{Name: "first", Template: script},
+			pipelineTask{Name: "first", TaskSpec: embeddedTask{
+				Metadata: taskMetadata{Labels: labels},
+				Steps:    []tektonStep{{Container: corev1.Container{Name: "run", Image: "alpine"}, Script: "echo hi"}},
+			}},
+			nil,
+		},
+		{
+			"pod scheduling",
+			&testv1alpha1.TestStep{Name: "first", Template: scheduled},
+			pipelineTask{Name: "first", TaskSpec: embeddedTask{
+				Metadata: taskMetadata{Labels: labels},
+				Steps:    []tektonStep{{Container: corev1.Container{Name: mainContainer, Image: "busybox"}}},
+			}},
+			&taskRunSpec{
+				PipelineTaskName:       "first",
+				TaskServiceAccountName: "runner",
+				TaskPodTemplate:        &tektonPodTemplate{NodeSelector: map[string]string{"pool": "tests"}, PriorityClassName: &low},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		task, spec := stepToPipelineTask(suite, c.step)
+		if !reflect.DeepEqual(task, c.expected) {
+			t.Errorf("%s: expected task %+v, got %+v", c.name, c.expected, task)
+		}
+		if !reflect.DeepEqual(spec, c.spec) {
+			t.Errorf("%s: expected taskrun spec %+v, got %+v", c.name, c.spec, spec)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+
+	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// syncTestRun snapshots the current execution of a suite into the TestRun named after its workflow,
+// creating it if necessary and garbage collecting runs beyond the suite's history limit
+func (r *TestSuiteReconciler) syncTestRun(ctx context.Context, suite *testv1alpha1.TestSuite) error {
+	var run testv1alpha1.TestRun
+	if err := r.Get(ctx, types.NamespacedName{Namespace: suite.Namespace, Name: suite.Status.WorkflowName}, &run); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+
+		run.Name = suite.Status.WorkflowName
+		run.Namespace = suite.Namespace
+		run.Labels = map[string]string{suiteLabel: suite.Name}
+		run.Spec = testv1alpha1.TestRunSpec{
+			TestSuite:    suite.Name,
+			WorkflowName: suite.Status.WorkflowName,
+			PluralId:     suite.Status.PluralId,
+		}
+		if err := controllerutil.SetControllerReference(suite, &run, r.Scheme); err != nil {
+			return err
+		}
+
+		if err := r.Create(ctx, &run); err != nil {
+			return err
+		}
+
+		if err := r.pruneTestRuns(ctx, suite); err != nil {
+			return err
+		}
+	}
+
+	run.Status.Status = suite.Status.Status
+	run.Status.Reason = suite.Status.Reason
+	run.Status.Steps = suite.DeepCopy().Status.Steps
+	if suite.Status.StartTime != nil {
+		run.Status.StartTime = suite.Status.StartTime.DeepCopy()
+	}
+	if suite.Status.CompletionTime != nil {
+		run.Status.CompletionTime = suite.Status.CompletionTime.DeepCopy()
+	}
+
+	return r.Status().Update(ctx, &run)
+}
+
+func (r *TestSuiteReconciler) pruneTestRuns(ctx context.Context, suite *testv1alpha1.TestSuite) error {
+	var runs testv1alpha1.TestRunList
+	if err := r.List(ctx, &runs, client.InNamespace(suite.Namespace), client.MatchingLabels{suiteLabel: suite.Name}); err != nil {
+		return err
+	}
+
+	// the current run counts towards the limit alongside the archived ones
+	limit := suiteHistoryLimit(suite) + 1
+	if len(runs.Items) <= limit {
+		return nil
+	}
+
+	sort.Slice(runs.Items, func(i, j int) bool {
+		return runs.Items[i].CreationTimestamp.Before(&runs.Items[j].CreationTimestamp)
+	})
+
+	for i := range runs.Items[:len(runs.Items)-limit] {
+		if err := r.Delete(ctx, &runs.Items[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	return nil
+}
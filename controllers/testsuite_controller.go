@@ -18,23 +18,25 @@ package controllers
 
 import (
 	"context"
-	"fmt"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/pluralsh/test-harness/pkg/plural"
-	"github.com/pluralsh/test-harness/pkg/utils"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/selection"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
-	argov1alpha1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
 	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
 	"github.com/pluralsh/test-harness/pkg/logs"
 	corev1 "k8s.io/api/core/v1"
-	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -43,29 +45,55 @@ type TestSuiteReconciler struct {
 	client.Client
 	Log        logr.Logger
 	Scheme     *runtime.Scheme
-	Plural     *plural.Client
+	Plural     plural.Api
 	LogManager *logs.LogManager
+	SuiteTTL   time.Duration
+	Executor   Executor
+	Commands   *CommandListener
+
+	// hashes of the test attributes last pushed to plural, keyed by plural id
+	pushed sync.Map
 }
 
 const (
-	ownedAnnotation    = "test.plural.sh/owned-by"
-	entrypointName     = "plrl-entrypoint"
-	serviceAccountName = "argo-executor"
-	suiteExpiry        = time.Hour * 24
+	ownedAnnotation  = "test.plural.sh/owned-by"
+	rerunAnnotation  = "test.plural.sh/rerun"
+	suiteLabel       = "test.plural.sh/suite"
+	runLabel         = "test.plural.sh/run"
+	managedLabel     = "test.plural.sh/managed"
+	cleanupFinalizer = "test.plural.sh/cleanup"
+	workflowLabel    = "workflows.argoproj.io/workflow"
+	nodeIDAnnotation = "workflows.argoproj.io/node-id"
+	// argo's choice of pod naming scheme for a workflow, v2 unless it says otherwise
+	podNameFormatAnnotation = "workflows.argoproj.io/pod-name-format"
+	historyLimit            = 10
+	serviceAccountName      = "argo-executor"
+	retainAnnotation        = "test.plural.sh/retain"
+	DefaultSuiteTTL         = time.Hour * 24
 )
 
 //+kubebuilder:rbac:groups=test.plural.sh,resources=testsuites,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=argoproj.io,resources=workflows,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=argoproj.io,resources=workflowtaskresults,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=tekton.dev,resources=pipelineruns,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=tekton.dev,resources=taskruns,verbs=get;list;watch
 //+kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;create;update
 //+kubebuilder:rbac:groups=core,resources=pods/log,verbs=get;list;watch;create;update;patch;delete
-//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterrolebindings,verbs=get;create;list;watch
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterrolebindings,verbs=get;create;list;watch;delete
+//+kubebuilder:rbac:groups=test.plural.sh,resources=testruns,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=test.plural.sh,resources=testruns/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=test.plural.sh,resources=testsuites/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=test.plural.sh,resources=testsuites/finalizers,verbs=update
 
 func (r *TestSuiteReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := r.Log.WithValues("testsuite", req.NamespacedName)
+	// any suite coming or going can change which repositories need a command channel
+	if r.Commands != nil {
+		r.Commands.Refresh()
+	}
 
 	var suite testv1alpha1.TestSuite
 	if err := r.Get(ctx, req.NamespacedName, &suite); err != nil {
@@ -73,51 +101,42 @@ func (r *TestSuiteReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	if suite.Status.WorkflowName == "" {
-		// suite hasn't been set up yet so set it up
-		log.Info("Creating new argo workflow for testsuite")
-		wf := suiteToWorkflow(&suite)
-		if err := controllerutil.SetControllerReference(&suite, &wf, r.Scheme); err != nil {
-			return ctrl.Result{}, err
-		}
-
-		if err := r.createServiceAccount(ctx, wf.Namespace, serviceAccountName); err != nil {
-			return ctrl.Result{}, err
-		}
-
-		if err := r.addMinimalRole(ctx, wf.Namespace, serviceAccountName); err != nil {
+	if !suite.DeletionTimestamp.IsZero() {
+		log.Info("Cleaning up deleted testsuite")
+		if err := r.finalize(ctx, &suite); err != nil {
+			log.Error(err, "failed to clean up testsuite")
 			return ctrl.Result{}, err
 		}
+		return ctrl.Result{}, nil
+	}
 
-		plrl := suiteToPluralTest(&suite)
-		tst, err := r.Plural.CreateTest(suite.Spec.Repository, plrl)
-		if err != nil {
-			log.Error(err, "failed to create plural test")
+	if !controllerutil.ContainsFinalizer(&suite, cleanupFinalizer) {
+		controllerutil.AddFinalizer(&suite, cleanupFinalizer)
+		if err := r.Update(ctx, &suite); err != nil {
+			log.Error(err, "failed to add cleanup finalizer")
 			return ctrl.Result{}, err
 		}
+	}
 
-		suite.Status.PluralId = tst.Id
-		statuses := stepStatuses(&suite)
-		for _, step := range tst.Steps {
-			if status, ok := statuses[step.Name]; ok {
-				status.PluralId = step.Id
-			}
-		}
-
-		if err := r.Create(ctx, &wf); err != nil {
-			log.Error(err, "failed to create workflow")
-			return ctrl.Result{}, err
+	if rerunRequested(&suite) {
+		log.Info("Rerunning testsuite")
+		if err := r.LogManager.Cancel(&suite); err != nil {
+			log.Error(err, "failed to cancel log watchers (this is not a critical error)")
 		}
-
-		if err := r.Status().Update(ctx, &suite); err != nil {
-			log.Error(err, "failed to update suite status")
+		if err := r.stopRun(ctx, &suite); err != nil {
+			log.Error(err, "failed to stop previous run")
 			return ctrl.Result{}, err
 		}
+		r.forgetPluralTest(suite.Status.PluralId)
+		archiveRun(&suite)
+	}
 
-		return ctrl.Result{}, nil
+	if suite.Status.WorkflowName == "" {
+		// suite hasn't been set up yet so set it up
+		return r.bootstrap(ctx, log, &suite)
 	}
 
-	if suiteCompleted(&suite) && suiteExpired(&suite) {
+	if suiteCompleted(&suite) && r.suiteExpired(&suite) {
 		if err := r.Delete(ctx, &suite); err != nil {
 			log.Error(err, "failed to delete testsuite")
 			return ctrl.Result{}, err
@@ -127,23 +146,55 @@ func (r *TestSuiteReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{}, nil
 	}
 
-	var wf argov1alpha1.Workflow
-	if err := r.Get(ctx, types.NamespacedName{Namespace: suite.Namespace, Name: suite.Status.WorkflowName}, &wf); err != nil {
-		log.Error(err, "could not find associated workflow")
+	if suite.Status.Status == plural.StatusCancelled {
+		return r.resyncFinished(ctx, &suite)
+	}
+
+	if err := r.Executor.Suspend(ctx, &suite); err != nil {
+		log.Error(err, "failed to update run suspension")
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	log.Info("Syncing workflow status to plural")
-	syncWorkflowStatus(&wf, &suite)
+	// a suite that already finished keeps its outcome, whatever is asked of it afterwards
+	cancelling := suite.Spec.Cancel != "" && !suiteCompleted(&suite)
+	if cancelling {
+		log.Info("Cancelling testsuite")
+		if err := r.Executor.Cancel(ctx, &suite); err != nil {
+			log.Error(err, "failed to shut down run")
+			return ctrl.Result{}, err
+		}
+	}
 
-	if err := r.ensureLogsTailed(ctx, &wf, &suite); err != nil {
-		log.Error(err, "failed tailing logs (this is a noncritical error)")
+	if cancelling && suite.Spec.Cancel == testv1alpha1.CancelTerminate {
+		markCancelled(&suite)
+	} else {
+		log.Info("Syncing run status to plural")
+		if err := r.Executor.Sync(ctx, &suite); err != nil {
+			log.Error(err, "could not sync associated run")
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+
+		if err := r.ensureLogsTailed(ctx, &suite); err != nil {
+			log.Error(err, "failed tailing logs (this is a noncritical error)")
+			setCondition(&suite, testv1alpha1.ConditionLogsStreaming, metav1.ConditionFalse, "TailFailed", err.Error())
+		}
+
+		// stopped runs let their running steps finish, so their outcome is synced until then
+		if cancelling {
+			markStopped(&suite)
+		}
 	}
+	syncCompletionConditions(&suite)
+	suite.Status.ObservedGeneration = suite.Generation
 
-	plrl := suiteToPluralTest(&suite)
-	if _, err := r.Plural.UpdateTest(suite.Status.PluralId, plrl); err != nil {
-		log.Error(err, "failed to update plural test")
-		return ctrl.Result{}, nil
+	if err := r.syncTestRun(ctx, &suite); err != nil {
+		log.Error(err, "failed to sync test run (this is a noncritical error)")
+	}
+
+	// the suite status is persisted even if plural couldn't be updated, the error requeues with backoff afterwards
+	pluralErr := r.syncPluralTest(&suite)
+	if pluralErr != nil {
+		log.Error(pluralErr, "failed to update plural test")
 	}
 
 	if err := r.Status().Update(ctx, &suite); err != nil {
@@ -151,116 +202,103 @@ func (r *TestSuiteReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{}, err
 	}
 
+	if pluralErr != nil {
+		return ctrl.Result{}, pluralErr
+	}
+
 	if suiteCompleted(&suite) && suite.Status.CompletionTime != nil {
-		expiry := suite.Status.CompletionTime.Time.Add(suiteExpiry)
 		log.Info("Scheduling testsuite for expiration")
 		if err := r.LogManager.Cancel(&suite); err != nil {
 			log.Error(err, "failed to cancel log watchers (this is not a critical error)")
 		}
 
-		return ctrl.Result{RequeueAfter: time.Until(expiry)}, nil
+		return r.expirationResult(&suite), nil
+	}
+
+	// executors don't necessarily enforce the suite timeout themselves, so make sure it's checked once it passes
+	if suite.Spec.Timeout != nil && suite.Status.StartTime != nil {
+		return ctrl.Result{RequeueAfter: time.Until(suite.Status.StartTime.Add(suite.Spec.Timeout.Duration))}, nil
 	}
 
 	return ctrl.Result{}, nil
 }
 
-func (r *TestSuiteReconciler) ensureLogsTailed(ctx context.Context, wf *argov1alpha1.Workflow, suite *testv1alpha1.TestSuite) error {
+// resyncFinished retries the push of a finished suite's final status until plural has it, then waits out its ttl
+func (r *TestSuiteReconciler) resyncFinished(ctx context.Context, suite *testv1alpha1.TestSuite) (ctrl.Result, error) {
+	if pluralSynced(suite) {
+		return r.expirationResult(suite), nil
+	}
+
+	pluralErr := r.syncPluralTest(suite)
+	if err := r.Status().Update(ctx, suite); err != nil {
+		return ctrl.Result{}, err
+	}
+	if pluralErr != nil {
+		return ctrl.Result{}, pluralErr
+	}
+	return r.expirationResult(suite), nil
+}
+
+func (r *TestSuiteReconciler) ensureLogsTailed(ctx context.Context, suite *testv1alpha1.TestSuite) error {
 	if suiteCompleted(suite) {
+		setCondition(suite, testv1alpha1.ConditionLogsStreaming, metav1.ConditionFalse, "Finished", "the test suite has finished")
 		return nil
 	}
 
+	// pending pods are left out, the pod watch will requeue the suite once they start
+	pods, err := r.Executor.Pods(ctx, suite)
+	if err != nil {
+		return err
+	}
+
 	statuses := stepStatuses(suite)
-	for _, nodeStatus := range wf.Status.Nodes {
-		if status, ok := statuses[nodeStatus.TemplateName]; ok {
-			var pod corev1.Pod
-			if err := r.Get(ctx, types.NamespacedName{Namespace: suite.Namespace, Name: nodeStatus.ID}, &pod); err != nil {
-				return err
-			}
+	selections := map[string]*testv1alpha1.LogSelection{}
+	for _, step := range suite.Spec.Steps {
+		selections[step.Name] = step.Logs
+	}
+
+	for step, stepPods := range pods {
+		status, ok := statuses[step]
+		if !ok {
+			continue
+		}
 
+		for _, pod := range stepPods {
 			mgr, err, _ := r.LogManager.SuiteManager(suite)
 			if err != nil {
 				return err
 			}
-			mgr.AddWatcher(&pod, status)
+			mgr.AddWatcher(pod, status, selections[step])
+			setCondition(suite, testv1alpha1.ConditionLogsStreaming, metav1.ConditionTrue, "Tailing", "step logs are being streamed to plural")
 		}
 	}
 
 	return nil
 }
 
-func (r *TestSuiteReconciler) createServiceAccount(ctx context.Context, namespace, sa string) error {
-	var serviceaccount corev1.ServiceAccount
-	if err := r.Get(ctx, types.NamespacedName{Name: sa, Namespace: namespace}, &serviceaccount); err != nil {
-		serviceaccount.Name = sa
-		serviceaccount.Namespace = namespace
-		return r.Create(ctx, &serviceaccount)
+// podToSuite maps a step pod back to the suite it runs for
+func (r *TestSuiteReconciler) podToSuite(obj client.Object) []reconcile.Request {
+	name, ok := obj.GetLabels()[suiteLabel]
+	if !ok {
+		return nil
 	}
-	return nil
-}
 
-func (r *TestSuiteReconciler) addMinimalRole(ctx context.Context, namespace, sa string) error {
-	var crb rbacv1.ClusterRoleBinding
-	name := fmt.Sprintf("%s-%s-argo-minimal-role", namespace, sa)
-	if err := r.Get(ctx, types.NamespacedName{Name: name}, &crb); err != nil {
-		crb.Name = name
-		crb.Subjects = []rbacv1.Subject{{Kind: "ServiceAccount", APIGroup: "", Name: sa, Namespace: namespace}}
-		crb.RoleRef = rbacv1.RoleRef{
-			APIGroup: "rbac.authorization.k8s.io",
-			Kind:     "ClusterRole",
-			Name:     "argo-workflow-minimal-role",
-		}
-
-		return r.Create(ctx, &crb)
-	}
-	return nil
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: name}}}
 }
 
-func syncWorkflowStatus(wf *argov1alpha1.Workflow, suite *testv1alpha1.TestSuite) {
-	suite.Status.Status = toPluralStatus(string(wf.Status.Phase))
-	statuses := stepStatuses(suite)
-	for _, nodeStatus := range wf.Status.Nodes {
-		if status, ok := statuses[nodeStatus.TemplateName]; ok {
-			status.Status = toPluralStatus(string(nodeStatus.Phase))
-		}
-	}
-
-	if suite.Status.Status == plural.StatusFailed || suite.Status.Status == plural.StatusSucceeded {
-		t := metav1.Now()
-		suite.Status.CompletionTime = &t
-	}
+// UncachedObjects are read straight from the api server by the manager's client, so reconciles always see the
+// latest suite and run
+func UncachedObjects() []client.Object {
+	return []client.Object{&testv1alpha1.TestSuite{}, &testv1alpha1.TestRun{}}
 }
 
-func suiteToWorkflow(suite *testv1alpha1.TestSuite) (workflow argov1alpha1.Workflow) {
-	name := fmt.Sprintf("%s-%s", suite.Name, utils.RandomStr(8))
-	workflow.Name = name
-	workflow.Namespace = suite.Namespace
-	workflow.Annotations = map[string]string{}
-	workflow.Annotations[ownedAnnotation] = suite.Name
-
-	workflow.Spec.Entrypoint = entrypointName
-	workflow.Spec.ServiceAccountName = serviceAccountName
-	templates := make([]argov1alpha1.Template, 0)
-	for _, step := range suite.Spec.Steps {
-		step.Template.Name = step.Name
-		templates = append(templates, *step.Template)
-	}
-
-	curr := suite.Spec.Steps[0].Name
-	dag := &argov1alpha1.DAGTemplate{}
-	tasks := []argov1alpha1.DAGTask{{Name: curr, Template: curr}}
-	for _, step := range suite.Spec.Steps[1:] {
-		tasks = append(tasks, argov1alpha1.DAGTask{
-			Name:         step.Name,
-			Template:     step.Name,
-			Dependencies: []string{curr},
-		})
-		curr = step.Name
-	}
-	dag.Tasks = tasks
-	workflow.Spec.Templates = append(templates, argov1alpha1.Template{DAG: dag, Name: entrypointName})
+// StepPodSelector restricts the pods cached by the manager to those run for a suite
+func StepPodSelector() labels.Selector {
+	req, _ := labels.NewRequirement(suiteLabel, selection.Exists, nil)
+	return labels.NewSelector().Add(*req)
+}
 
-	// wire in workflow details to the base suite resource
-	suite.Status.WorkflowName = name
+func initSuiteStatus(suite *testv1alpha1.TestSuite) {
 	suite.Status.Status = plural.StatusQueued
 	steps := make([]*testv1alpha1.StepStatus, 0)
 	for _, step := range suite.Spec.Steps {
@@ -270,13 +308,13 @@ func suiteToWorkflow(suite *testv1alpha1.TestSuite) (workflow argov1alpha1.Workf
 		})
 	}
 	suite.Status.Steps = steps
-	return
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *TestSuiteReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&testv1alpha1.TestSuite{}).
-		Owns(&argov1alpha1.Workflow{}).
+		Owns(r.Executor.Object()).
+		Watches(&source.Kind{Type: &corev1.Pod{}}, handler.EnqueueRequestsFromMapFunc(r.podToSuite)).
 		Complete(r)
 }
@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	argov1alpha1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
+	"github.com/pluralsh/test-harness/pkg/plural"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("TestSuite controller", func() {
+	const (
+		timeout  = 10 * time.Second
+		interval = 250 * time.Millisecond
+	)
+
+	It("registers new suites with plural and starts their first step", func() {
+		ctx := context.Background()
+		suite := &testv1alpha1.TestSuite{
+			ObjectMeta: metav1.ObjectMeta{Name: "register", Namespace: "default"},
+			Spec: testv1alpha1.TestSuiteSpec{
+				Repository: "airbyte",
+				PromoteTag: "warm",
+				Steps: []*testv1alpha1.TestStep{
+					{Name: "first", Description: "first step", Template: &argov1alpha1.Template{Container: &corev1.Container{Image: "busybox"}}},
+					{Name: "second", Description: "second step", Template: &argov1alpha1.Template{Container: &corev1.Container{Image: "busybox"}}},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, suite)).To(Succeed())
+
+		key := types.NamespacedName{Name: suite.Name, Namespace: suite.Namespace}
+		Eventually(func() string {
+			var found testv1alpha1.TestSuite
+			if err := k8sClient.Get(ctx, key, &found); err != nil {
+				return ""
+			}
+			return found.Status.WorkflowName
+		}, timeout, interval).ShouldNot(BeEmpty())
+
+		var found testv1alpha1.TestSuite
+		Expect(k8sClient.Get(ctx, key, &found)).To(Succeed())
+
+		calls := plrlServer.Calls(plural.MethodCreateTest)
+		Expect(calls).To(HaveLen(1))
+		Expect(calls[0].Repo).To(Equal("airbyte"))
+
+		test, ok := plrlServer.Test(found.Status.PluralId)
+		Expect(ok).To(BeTrue())
+		Expect(test.Name).To(Equal(suite.Name))
+		Expect(test.Steps).To(HaveLen(2))
+		for _, step := range found.Status.Steps {
+			Expect(step.PluralId).NotTo(BeEmpty())
+		}
+
+		var job batchv1.Job
+		jobKey := types.NamespacedName{Name: jobName(&found, "first"), Namespace: suite.Namespace}
+		Eventually(func() error {
+			return k8sClient.Get(ctx, jobKey, &job)
+		}, timeout, interval).Should(Succeed())
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: jobName(&found, "second"), Namespace: suite.Namespace}, &job)).NotTo(Succeed())
+	})
+
+	It("applies commands sent from plural", func() {
+		ctx := context.Background()
+		suite := &testv1alpha1.TestSuite{
+			ObjectMeta: metav1.ObjectMeta{Name: "commands", Namespace: "default"},
+			Spec: testv1alpha1.TestSuiteSpec{
+				Repository: "console",
+				Steps: []*testv1alpha1.TestStep{
+					{Name: "only", Description: "only step", Template: &argov1alpha1.Template{Container: &corev1.Container{Image: "busybox"}}},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, suite)).To(Succeed())
+
+		key := types.NamespacedName{Name: suite.Name, Namespace: suite.Namespace}
+		var found testv1alpha1.TestSuite
+		Eventually(func() string {
+			if err := k8sClient.Get(ctx, key, &found); err != nil {
+				return ""
+			}
+			return found.Status.PluralId
+		}, timeout, interval).ShouldNot(BeEmpty())
+
+		// the command channel is joined in the background, so keep sending until it lands
+		Eventually(func() testv1alpha1.CancelStrategy {
+			cmd := Command{Test: found.Status.PluralId, Strategy: testv1alpha1.CancelTerminate}
+			Expect(plrlServer.Broadcast(commandTopic("console"), CommandCancel, cmd)).To(Succeed())
+			if err := k8sClient.Get(ctx, key, &found); err != nil {
+				return ""
+			}
+			return found.Spec.Cancel
+		}, timeout, interval).Should(Equal(testv1alpha1.CancelTerminate))
+	})
+
+	It("finds the target of a command by plural id with testsuites read uncached", func() {
+		ctx := context.Background()
+		suite := &testv1alpha1.TestSuite{
+			ObjectMeta: metav1.ObjectMeta{Name: "promote", Namespace: "default"},
+			Spec: testv1alpha1.TestSuiteSpec{
+				Repository: "promote",
+				Steps: []*testv1alpha1.TestStep{
+					{Name: "only", Description: "only step", Template: &argov1alpha1.Template{Container: &corev1.Container{Image: "busybox"}}},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, suite)).To(Succeed())
+
+		key := types.NamespacedName{Name: suite.Name, Namespace: suite.Namespace}
+		var found testv1alpha1.TestSuite
+		Eventually(func() string {
+			if err := k8sClient.Get(ctx, key, &found); err != nil {
+				return ""
+			}
+			return found.Status.PluralId
+		}, timeout, interval).ShouldNot(BeEmpty())
+
+		// the index is only kept in the manager's cache, which catches up with the status in the background
+		Eventually(func() error {
+			return commands.handle(CommandPromote, Command{Test: found.Status.PluralId, Tag: "warm"})
+		}, timeout, interval).Should(Succeed())
+		Expect(k8sClient.Get(ctx, key, &found)).To(Succeed())
+		Expect(found.Spec.PromoteTag).To(Equal("warm"))
+
+		Expect(commands.handle(CommandRerun, Command{Test: found.Status.PluralId})).To(Succeed())
+		Expect(k8sClient.Get(ctx, key, &found)).To(Succeed())
+		Expect(found.Annotations).To(HaveKey(rerunAnnotation))
+
+		Expect(commands.handle(CommandPromote, Command{Test: "unknown", Tag: "warm"})).NotTo(Succeed())
+	})
+})
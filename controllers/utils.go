@@ -1,12 +1,26 @@
 package controllers
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
 	"time"
 
+	argov1alpha1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
 	"github.com/pluralsh/gqlclient"
 	"github.com/pluralsh/gqlclient/pkg/utils"
 	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
 	"github.com/pluralsh/test-harness/pkg/plural"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 func suiteCompleted(suite *testv1alpha1.TestSuite) bool {
@@ -14,19 +28,195 @@ func suiteCompleted(suite *testv1alpha1.TestSuite) bool {
 		return true
 	}
 
-	return suite.Status.Status == plural.StatusSucceeded || suite.Status.Status == plural.StatusFailed
+	switch suite.Status.Status {
+	case plural.StatusSucceeded, plural.StatusFailed, plural.StatusCancelled:
+		return true
+	}
+	return false
 }
 
-func suiteExpired(suite *testv1alpha1.TestSuite) bool {
+// suiteTTL returns how long a suite is kept after it finishes, or false if it's pinned and should never expire
+func (r *TestSuiteReconciler) suiteTTL(suite *testv1alpha1.TestSuite) (time.Duration, bool) {
+	if suite.Annotations[retainAnnotation] == "true" {
+		return 0, false
+	}
+
+	// a zero ttl removes finished suites straight away
+	ttl := r.SuiteTTL
+	seconds := suite.Spec.TTLSecondsAfterFinished
+	switch suite.Status.Status {
+	case plural.StatusSucceeded:
+		if suite.Spec.TTLSecondsAfterSuccess != nil {
+			seconds = suite.Spec.TTLSecondsAfterSuccess
+		}
+	case plural.StatusFailed, plural.StatusCancelled:
+		if suite.Spec.TTLSecondsAfterFailure != nil {
+			seconds = suite.Spec.TTLSecondsAfterFailure
+		}
+	}
+
+	if seconds != nil {
+		ttl = time.Duration(*seconds) * time.Second
+	}
+	return ttl, true
+}
+
+// suiteExpired checks whether a finished suite has outlived its ttl.  Pinned suites never expire, and a suite with no
+// completion time only counts as expired once its status is terminal, since there's nothing to count the ttl from
+func (r *TestSuiteReconciler) suiteExpired(suite *testv1alpha1.TestSuite) bool {
+	ttl, ok := r.suiteTTL(suite)
+	if !ok {
+		return false
+	}
+
 	if suite.Status.CompletionTime == nil {
+		switch suite.Status.Status {
+		case plural.StatusSucceeded, plural.StatusFailed, plural.StatusCancelled:
+			return true
+		}
+		return false
+	}
+
+	return suite.Status.CompletionTime.Time.Add(ttl).Before(time.Now())
+}
+
+func (r *TestSuiteReconciler) expirationResult(suite *testv1alpha1.TestSuite) ctrl.Result {
+	ttl, ok := r.suiteTTL(suite)
+	if !ok || suite.Status.CompletionTime == nil {
+		return ctrl.Result{}
+	}
+
+	return ctrl.Result{RequeueAfter: time.Until(suite.Status.CompletionTime.Time.Add(ttl))}
+}
+
+// rerunRequested checks whether the rerun annotation has changed, or the spec has changed for suites that rerun on change
+func rerunRequested(suite *testv1alpha1.TestSuite) bool {
+	if suite.Status.WorkflowName == "" {
+		return false
+	}
+
+	if token, ok := suite.Annotations[rerunAnnotation]; ok && token != suite.Status.RerunToken {
 		return true
 	}
 
-	return suite.Status.CompletionTime.Time.Add(suiteExpiry).Before(time.Now())
+	return suite.Spec.RerunOnChange && suite.Status.ObservedGeneration != 0 && suite.Generation != suite.Status.ObservedGeneration
+}
+
+// archiveRun moves the current run into the suite's history and resets its status so it will be set up again
+func archiveRun(suite *testv1alpha1.TestSuite) {
+	run := &testv1alpha1.RunStatus{
+		PluralId:       suite.Status.PluralId,
+		WorkflowName:   suite.Status.WorkflowName,
+		Status:         suite.Status.Status,
+		Reason:         suite.Status.Reason,
+		Steps:          suite.Status.Steps,
+		CompletionTime: suite.Status.CompletionTime,
+	}
+
+	history := append([]*testv1alpha1.RunStatus{run}, suite.Status.History...)
+	if limit := suiteHistoryLimit(suite); len(history) > limit {
+		history = history[:limit]
+	}
+
+	suite.Status = testv1alpha1.TestSuiteStatus{
+		History:    history,
+		RerunToken: suite.Status.RerunToken,
+		Runs:       suite.Status.Runs,
+	}
+}
+
+// workflowName is deterministic for each run of a suite, so retried bootstraps find the workflow they already created
+func workflowName(suite *testv1alpha1.TestSuite) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%d", suite.UID, suite.Status.Runs)))
+	return fmt.Sprintf("%s-%s", suite.Name, hex.EncodeToString(sum[:])[:8])
+}
+
+// runLabels identify everything created for the suite's current run
+func runLabels(suite *testv1alpha1.TestSuite) client.MatchingLabels {
+	return client.MatchingLabels{
+		suiteLabel: suite.Name,
+		runLabel:   strconv.Itoa(int(suite.Status.Runs)),
+	}
+}
+
+// syncCompletionTime stamps the suite once its run has finished
+func syncCompletionTime(suite *testv1alpha1.TestSuite) {
+	if suite.Status.CompletionTime == nil && (suite.Status.Status == plural.StatusFailed || suite.Status.Status == plural.StatusSucceeded) {
+		t := metav1.Now()
+		suite.Status.CompletionTime = &t
+	}
+}
+
+// runPods lists the pods of the suite's current run, oldest first, keyed by the step named in the given label
+func runPods(ctx context.Context, c client.Client, suite *testv1alpha1.TestSuite, label string) (map[string][]*corev1.Pod, error) {
+	var pods corev1.PodList
+	if err := c.List(ctx, &pods, client.InNamespace(suite.Namespace), runLabels(suite)); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(pods.Items, func(i, j int) bool {
+		return pods.Items[i].CreationTimestamp.Before(&pods.Items[j].CreationTimestamp)
+	})
+
+	res := map[string][]*corev1.Pod{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if step, ok := pod.Labels[label]; ok {
+			res[step] = append(res[step], pod)
+		}
+	}
+	return res, nil
+}
+
+func startedPods(pods map[string][]*corev1.Pod) map[string][]*corev1.Pod {
+	res := map[string][]*corev1.Pod{}
+	for step, stepPods := range pods {
+		for _, pod := range stepPods {
+			if podStarted(pod) {
+				res[step] = append(res[step], pod)
+			}
+		}
+	}
+	return res
+}
+
+// podStarted filters out pods that have no logs yet and deleted ones that have already been tailed
+func podStarted(pod *corev1.Pod) bool {
+	return pod.Status.Phase != corev1.PodPending && pod.DeletionTimestamp.IsZero()
+}
+
+func suiteHistoryLimit(suite *testv1alpha1.TestSuite) int {
+	if suite.Spec.HistoryLimit == nil {
+		return historyLimit
+	}
+
+	return int(*suite.Spec.HistoryLimit)
+}
+
+// workflowPodName mirrors argo's naming of a node's pod, which depends on the workflow's pod name format.  Argo's
+// own helper drags in most of its controller's dependencies, so its few lines are copied here.
+func workflowPodName(wf *argov1alpha1.Workflow, node argov1alpha1.NodeStatus) string {
+	if wf.Annotations[podNameFormatAnnotation] == "v1" {
+		return node.ID
+	}
+
+	if wf.Name == node.Name {
+		return wf.Name
+	}
+
+	// the prefix leaves room for a dash and the hash within the 253 characters a name can have
+	prefix := fmt.Sprintf("%s-%s", wf.Name, node.TemplateName)
+	if len(prefix) > 242 {
+		prefix = prefix[:242]
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(node.Name))
+	return fmt.Sprintf("%s-%v", prefix, h.Sum32())
 }
 
 func suiteToPluralTest(suite *testv1alpha1.TestSuite) (test gqlclient.TestAttributes) {
-	status := gqlclient.TestStatus(suite.Status.Status)
+	status := toTestStatus(suite.Status.Status)
 	test.Name = &suite.Name
 	test.Status = &status
 	test.PromoteTag = &suite.Spec.PromoteTag
@@ -41,14 +231,19 @@ func suiteToPluralTest(suite *testv1alpha1.TestSuite) (test gqlclient.TestAttrib
 			stepStatus = status.Status
 		}
 
+		description := step.Description
+		if ok {
+			description = stepDescription(step, status)
+		}
+
 		tsa := &gqlclient.TestStepAttributes{
 			Name:        &step.Name,
-			Description: &step.Description,
+			Description: &description,
 		}
 		if status.PluralId != "" {
 			tsa.ID = &status.PluralId
 		}
-		tsaStatus := gqlclient.TestStatus(stepStatus)
+		tsaStatus := toTestStatus(stepStatus)
 		tsa.Status = &tsaStatus
 		test.Steps = append(test.Steps, tsa)
 	}
@@ -56,6 +251,29 @@ func suiteToPluralTest(suite *testv1alpha1.TestSuite) (test gqlclient.TestAttrib
 	return
 }
 
+func stepDescription(step *testv1alpha1.TestStep, status *testv1alpha1.StepStatus) string {
+	description := step.Description
+	if status.Attempts > 1 {
+		description = fmt.Sprintf("%s (attempt %d)", description, status.Attempts)
+	}
+	switch status.Reason {
+	case testv1alpha1.ReasonTimedOut:
+		description = fmt.Sprintf("%s (timed out)", description)
+	case testv1alpha1.ReasonCancelled:
+		description = fmt.Sprintf("%s (cancelled)", description)
+	}
+	return description
+}
+
+// toTestStatus converts to the statuses plural understands, which has no notion of cancellation
+func toTestStatus(status plural.Status) gqlclient.TestStatus {
+	if status == plural.StatusCancelled {
+		return gqlclient.TestStatusFailed
+	}
+
+	return gqlclient.TestStatus(status)
+}
+
 func stepStatuses(suite *testv1alpha1.TestSuite) map[string]*testv1alpha1.StepStatus {
 	res := map[string]*testv1alpha1.StepStatus{}
 	for _, step := range suite.Status.Steps {
@@ -80,3 +298,74 @@ func toPluralStatus(argo string) plural.Status {
 
 	return plural.StatusQueued
 }
+
+// timedOut checks whether argo failed a node or workflow because it ran past its deadline, which is the case when
+// it was still running once the deadline had passed
+func timedOut(phase string, startedAt, finishedAt metav1.Time, timeout *metav1.Duration) bool {
+	if timeout == nil || phase != "Failed" && phase != "Error" {
+		return false
+	}
+
+	if startedAt.IsZero() || finishedAt.IsZero() {
+		return false
+	}
+
+	deadline := time.Duration(deadlineSeconds(timeout)) * time.Second
+	return !startedAt.Add(deadline).After(finishedAt.Time)
+}
+
+func deadlineSeconds(timeout *metav1.Duration) int64 {
+	return int64(math.Ceil(timeout.Seconds()))
+}
+
+// toRetryStrategy converts a step's retry policy.  argo's activeDeadlineSeconds only bounds a single pod, so a
+// step timeout also caps the backoff's maxDuration, which argo counts from the start of the first attempt
+func toRetryStrategy(retry *testv1alpha1.RetryPolicy, timeout *metav1.Duration) *argov1alpha1.RetryStrategy {
+	limit := intstr.FromInt(int(retry.Limit))
+	strategy := &argov1alpha1.RetryStrategy{
+		Limit:       &limit,
+		RetryPolicy: argov1alpha1.RetryPolicyOnFailure,
+	}
+
+	switch retry.RetryOn {
+	case testv1alpha1.RetryOnError:
+		strategy.RetryPolicy = argov1alpha1.RetryPolicyOnError
+	case testv1alpha1.RetryOnAlways:
+		strategy.RetryPolicy = argov1alpha1.RetryPolicyAlways
+	}
+
+	if backoff := retry.Backoff; backoff != nil {
+		strategy.Backoff = &argov1alpha1.Backoff{
+			Duration:    backoff.Duration,
+			MaxDuration: backoff.MaxDuration,
+		}
+		if backoff.Factor != nil {
+			factor := intstr.FromInt(int(*backoff.Factor))
+			strategy.Backoff.Factor = &factor
+		}
+	}
+
+	if timeout != nil {
+		if strategy.Backoff == nil {
+			strategy.Backoff = &argov1alpha1.Backoff{}
+		}
+		// argo ignores a backoff without an initial delay
+		if strategy.Backoff.Duration == "" {
+			strategy.Backoff.Duration = "0"
+		}
+		if max, ok := parseArgoDuration(strategy.Backoff.MaxDuration); !ok || max > timeout.Duration {
+			strategy.Backoff.MaxDuration = timeout.Duration.String()
+		}
+	}
+
+	return strategy
+}
+
+// parseArgoDuration reads a duration the way argo does, as either a number of seconds or a duration string
+func parseArgoDuration(value string) (time.Duration, bool) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	d, err := time.ParseDuration(value)
+	return d, err == nil
+}
@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
+	"github.com/pluralsh/test-harness/pkg/plural"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSuiteExpired(t *testing.T) {
+	r := &TestSuiteReconciler{SuiteTTL: time.Hour}
+	longAgo := metav1.NewTime(time.Now().Add(-2 * time.Hour))
+	recently := metav1.NewTime(time.Now().Add(-time.Minute))
+	retained := map[string]string{retainAnnotation: "true"}
+
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		status      plural.Status
+		completed   *metav1.Time
+		expected    bool
+	}{
+		{"past its ttl", nil, plural.StatusSucceeded, &longAgo, true},
+		{"within its ttl", nil, plural.StatusSucceeded, &recently, false},
+		{"retained past its ttl", retained, plural.StatusFailed, &longAgo, false},
+		{"retained without a completion time", retained, plural.StatusFailed, nil, false},
+		{"terminal without a completion time", nil, plural.StatusCancelled, nil, true},
+		{"running without a completion time", nil, plural.StatusRunning, nil, false},
+		{"queued without a completion time", nil, plural.StatusQueued, nil, false},
+	}
+
+	for _, c := range cases {
+		suite := &testv1alpha1.TestSuite{
+			ObjectMeta: metav1.ObjectMeta{Name: "suite", Annotations: c.annotations},
+			Status:     testv1alpha1.TestSuiteStatus{Status: c.status, CompletionTime: c.completed},
+		}
+		if res := r.suiteExpired(suite); res != c.expected {
+			t.Errorf("%s: expected expired to be %v", c.name, c.expected)
+		}
+	}
+}
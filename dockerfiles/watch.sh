@@ -1,4 +1,4 @@
 #!/bin/bash
 
 sleep 10
-kubectl wait --for=condition=ready --timeout=30m -n $1 applications.app.k8s.io/$1
\ No newline at end of file
+kubectl wait --for=condition=ready --timeout=${2:-30m} -n $1 applications.app.k8s.io/$1
\ No newline at end of file
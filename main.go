@@ -19,18 +19,21 @@ package main
 import (
 	"flag"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	argov1alpha1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
 	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
@@ -57,11 +60,27 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var suiteTTL time.Duration
+	var executor string
+	var outboxDir string
+	flush := logs.DefaultFlushOptions
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.DurationVar(&suiteTTL, "suite-ttl", controllers.DefaultSuiteTTL,
+		"How long to keep finished testsuites, unless overridden by the suite's ttlSecondsAfterFinished. Zero deletes them as soon as they finish.")
+	flag.StringVar(&executor, "executor", controllers.ExecutorArgo,
+		"The backend test steps are run on, one of argo for argo workflows, jobs for plain kubernetes jobs or tekton for tekton pipelineruns.")
+	flag.StringVar(&outboxDir, "outbox-dir", "",
+		"Directory to persist plural updates that fail to go through until they can be replayed, they're dropped if unset.")
+	flag.DurationVar(&flush.MaxLatency, "log-flush-interval", flush.MaxLatency,
+		"The longest a step's log lines are buffered before being published to plural.")
+	flag.IntVar(&flush.MaxLines, "log-flush-lines", flush.MaxLines,
+		"The number of buffered log lines that triggers publishing a step's logs to plural.")
+	flag.IntVar(&flush.MaxBytes, "log-flush-bytes", flush.MaxBytes,
+		"The size in bytes of buffered log lines that triggers publishing a step's logs to plural.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -76,25 +95,73 @@ func main() {
 		Port:                   9443,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
-		ClientDisableCacheFor:  []client.Object{&testv1alpha1.TestSuite{}},
-		LeaderElectionID:       "04d3e635.plural.sh",
+		ClientDisableCacheFor:  controllers.UncachedObjects(),
+		NewCache: cache.BuilderWithOptions(cache.Options{
+			SelectorsByObject: cache.SelectorsByObject{
+				&corev1.Pod{}: {Label: controllers.StepPodSelector()},
+			},
+		}),
+		LeaderElectionID: "04d3e635.plural.sh",
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	exec, err := controllers.NewExecutor(executor, mgr.GetClient(), mgr.GetScheme())
+	if err != nil {
+		setupLog.Error(err, "unable to create executor")
+		os.Exit(1)
+	}
+
 	plrl := plural.NewConfig()
+	logManager := logs.NewManager(plrl)
+	logManager.Flush = flush
+	var plrlClient plural.Api = plural.NewClient(plrl)
+	if outboxDir != "" {
+		box, err := plural.NewOutbox(plrlClient, outboxDir)
+		if err != nil {
+			setupLog.Error(err, "unable to load plural outbox")
+			os.Exit(1)
+		}
+		if err := mgr.Add(box); err != nil {
+			setupLog.Error(err, "unable to add plural outbox")
+			os.Exit(1)
+		}
+		metrics.Registry.MustRegister(box.Collectors()...)
+		plrlClient = box
+		logManager.Client = box
+	}
+
+	commands := &controllers.CommandListener{
+		Client: mgr.GetClient(),
+		Socket: logManager.Socket,
+		Log:    ctrl.Log.WithName("controllers").WithName("Commands"),
+	}
+	if err := commands.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to set up command listener")
+		os.Exit(1)
+	}
+
 	if err = (&controllers.TestSuiteReconciler{
 		Client:     mgr.GetClient(),
 		Scheme:     mgr.GetScheme(),
-		Plural:     plural.NewClient(plrl),
-		LogManager: logs.NewManager(plrl),
+		Plural:     plrlClient,
+		LogManager: logManager,
+		SuiteTTL:   suiteTTL,
+		Executor:   exec,
+		Commands:   commands,
 		Log:        ctrl.Log.WithName("controllers").WithName("TestSuite"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "TestSuite")
 		os.Exit(1)
 	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&testv1alpha1.TestSuite{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "TestSuite")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -0,0 +1,174 @@
+package logs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const checkpointInterval = 10 * time.Second
+
+// Checkpoint marks how far a container's log stream has been delivered to plural.  Lines are identified by
+// their kubelet timestamp, along with how many lines sharing that exact timestamp have gone out.
+type Checkpoint struct {
+	Time  string `json:"time"`
+	Lines int    `json:"lines"`
+}
+
+// PodCheckpoint holds the checkpoints for every container in a pod
+type PodCheckpoint struct {
+	Containers map[string]*Checkpoint `json:"containers,omitempty"`
+	// whether the full log file has been uploaded, after which the pod never needs tailing again
+	Uploaded bool `json:"uploaded,omitempty"`
+}
+
+// CheckpointStore persists log checkpoints for a suite's pods in a configmap owned by the suite, so tailing can
+// resume where it left off after a controller restart
+type CheckpointStore struct {
+	mu     sync.Mutex
+	Client kubernetes.Interface
+	Suite  *testv1alpha1.TestSuite
+}
+
+// Load returns the pod's checkpoint, which is empty if it has never been tailed
+func (store *CheckpointStore) Load(ctx context.Context, pod string) (*PodCheckpoint, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	cp := &PodCheckpoint{Containers: map[string]*Checkpoint{}}
+	cm, err := store.Client.CoreV1().ConfigMaps(store.Suite.Namespace).Get(ctx, store.name(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if data, ok := cm.Data[pod]; ok {
+		if err := json.Unmarshal([]byte(data), cp); err != nil {
+			return nil, err
+		}
+	}
+	if cp.Containers == nil {
+		cp.Containers = map[string]*Checkpoint{}
+	}
+	return cp, nil
+}
+
+func (store *CheckpointStore) Save(ctx context.Context, pod string, cp *PodCheckpoint) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	configMaps := store.Client.CoreV1().ConfigMaps(store.Suite.Namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := configMaps.Get(ctx, store.name(), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			cm = store.configMap()
+			cm.Data[pod] = string(data)
+			_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[pod] = string(data)
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (store *CheckpointStore) name() string {
+	return fmt.Sprintf("%s-log-checkpoints", store.Suite.Name)
+}
+
+func (store *CheckpointStore) configMap() *corev1.ConfigMap {
+	suite := store.Suite
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      store.name(),
+			Namespace: suite.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(suite, testv1alpha1.GroupVersion.WithKind("TestSuite")),
+			},
+		},
+		Data: map[string]string{},
+	}
+}
+
+// advance moves the checkpoint past a line with the given timestamp
+func (cp *Checkpoint) advance(ts string) {
+	if cp.Time == ts {
+		cp.Lines++
+		return
+	}
+	cp.Time, cp.Lines = ts, 1
+}
+
+// covers checks whether the line the cursor has just advanced past was already delivered before the checkpoint
+// was taken
+func (cp *Checkpoint) covers(cursor *Checkpoint) bool {
+	if cp == nil || cp.Time == "" {
+		return false
+	}
+
+	if cursor.Time == cp.Time {
+		return cursor.Lines <= cp.Lines
+	}
+
+	at, err := time.Parse(time.RFC3339Nano, cursor.Time)
+	if err != nil {
+		return false
+	}
+	since, err := time.Parse(time.RFC3339Nano, cp.Time)
+	if err != nil {
+		return false
+	}
+	return at.Before(since)
+}
+
+// sinceTime is where to resume the container's stream from.  The api only takes second precision, so lines
+// earlier in that second are sent again and skipped using the checkpoint.
+func (cp *Checkpoint) sinceTime() *metav1.Time {
+	if cp == nil {
+		return nil
+	}
+
+	since, err := time.Parse(time.RFC3339Nano, cp.Time)
+	if err != nil {
+		return nil
+	}
+	return &metav1.Time{Time: since.Truncate(time.Second)}
+}
+
+// splitTimestamp separates the timestamp the kubelet prefixes lines with when asked for them
+func splitTimestamp(line string) (string, string) {
+	ts, rest, ok := strings.Cut(line, " ")
+	if !ok {
+		if _, err := time.Parse(time.RFC3339Nano, line); err == nil {
+			return line, ""
+		}
+		return "", line
+	}
+
+	if _, err := time.Parse(time.RFC3339Nano, ts); err != nil {
+		return "", line
+	}
+	return ts, rest
+}
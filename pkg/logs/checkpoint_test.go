@@ -0,0 +1,250 @@
+package logs
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
+	"github.com/pluralsh/test-harness/pkg/plural"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCheckpointAdvance(t *testing.T) {
+	cases := []struct {
+		name     string
+		lines    []string
+		expected Checkpoint
+	}{
+		{"counts lines sharing a timestamp", []string{"a", "a", "a"}, Checkpoint{Time: "a", Lines: 3}},
+		{"restarts the count on a new timestamp", []string{"a", "a", "b"}, Checkpoint{Time: "b", Lines: 1}},
+		{"counts lines without a timestamp", []string{"", ""}, Checkpoint{Time: "", Lines: 2}},
+	}
+
+	for _, c := range cases {
+		cp := Checkpoint{}
+		for _, ts := range c.lines {
+			cp.advance(ts)
+		}
+		if cp != c.expected {
+			t.Errorf("%s: expected %+v, got %+v", c.name, c.expected, cp)
+		}
+	}
+}
+
+func TestCheckpointCovers(t *testing.T) {
+	cp := &Checkpoint{Time: "2023-01-01T00:00:05.5Z", Lines: 2}
+	cases := []struct {
+		name     string
+		cp       *Checkpoint
+		cursor   Checkpoint
+		expected bool
+	}{
+		{"no checkpoint", nil, Checkpoint{Time: "2023-01-01T00:00:00Z", Lines: 1}, false},
+		{"empty checkpoint", &Checkpoint{}, Checkpoint{Time: "2023-01-01T00:00:00Z", Lines: 1}, false},
+		{"earlier line", cp, Checkpoint{Time: "2023-01-01T00:00:05.25Z", Lines: 1}, true},
+		{"delivered line on the same timestamp", cp, Checkpoint{Time: "2023-01-01T00:00:05.5Z", Lines: 2}, true},
+		{"new line on the same timestamp", cp, Checkpoint{Time: "2023-01-01T00:00:05.5Z", Lines: 3}, false},
+		{"later line", cp, Checkpoint{Time: "2023-01-01T00:00:05.75Z", Lines: 1}, false},
+		{"line without a timestamp", cp, Checkpoint{Lines: 1}, false},
+	}
+
+	for _, c := range cases {
+		if res := c.cp.covers(&c.cursor); res != c.expected {
+			t.Errorf("%s: expected covers to be %v", c.name, c.expected)
+		}
+	}
+}
+
+func TestCheckpointSinceTime(t *testing.T) {
+	cases := []struct {
+		name     string
+		cp       *Checkpoint
+		expected *metav1.Time
+	}{
+		{"no checkpoint", nil, nil},
+		{"unparseable timestamp", &Checkpoint{Time: "yesterday"}, nil},
+		{"truncated to the second", &Checkpoint{Time: "2023-01-01T00:00:05.75Z", Lines: 1}, &metav1.Time{Time: time.Date(2023, 1, 1, 0, 0, 5, 0, time.UTC)}},
+		{"whole second", &Checkpoint{Time: "2023-01-01T00:00:05Z", Lines: 1}, &metav1.Time{Time: time.Date(2023, 1, 1, 0, 0, 5, 0, time.UTC)}},
+	}
+
+	for _, c := range cases {
+		since := c.cp.sinceTime()
+		if (since == nil) != (c.expected == nil) || (since != nil && !since.Equal(c.expected)) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, since)
+		}
+	}
+}
+
+// a resumed stream starts at the checkpoint's second, so everything up to the delivered lines is replayed
+func TestCheckpointSkipsReplayedLines(t *testing.T) {
+	last := &Checkpoint{Time: "2023-01-01T00:00:05.5Z", Lines: 2}
+	replayed := []string{
+		"2023-01-01T00:00:05.25Z",
+		"2023-01-01T00:00:05.5Z",
+		"2023-01-01T00:00:05.5Z",
+		"2023-01-01T00:00:05.5Z",
+		"2023-01-01T00:00:06Z",
+	}
+
+	cursor := &Checkpoint{}
+	skipped := make([]bool, 0, len(replayed))
+	for _, ts := range replayed {
+		cursor.advance(ts)
+		skipped = append(skipped, last.covers(cursor))
+	}
+
+	expected := []bool{true, true, true, false, false}
+	if !reflect.DeepEqual(skipped, expected) {
+		t.Errorf("expected lines skipped %v, got %v", expected, skipped)
+	}
+}
+
+func TestSplitTimestamp(t *testing.T) {
+	cases := []struct {
+		name string
+		raw  string
+		ts   string
+		line string
+	}{
+		{"timestamped line", "2023-01-01T00:00:05.5Z hello world", "2023-01-01T00:00:05.5Z", "hello world"},
+		{"timestamped empty line", "2023-01-01T00:00:05.5Z", "2023-01-01T00:00:05.5Z", ""},
+		{"line without a timestamp", "hello world", "", "hello world"},
+		{"single word", "hello", "", "hello"},
+		{"empty line", "", "", ""},
+	}
+
+	for _, c := range cases {
+		if ts, line := splitTimestamp(c.raw); ts != c.ts || line != c.line {
+			t.Errorf("%s: expected (%q, %q), got (%q, %q)", c.name, c.ts, c.line, ts, line)
+		}
+	}
+}
+
+func TestCheckpointStore(t *testing.T) {
+	ctx := context.Background()
+	suite := testSuite("checkpoints")
+	store := &CheckpointStore{Client: fake.NewSimpleClientset(), Suite: suite}
+
+	cp, err := store.Load(ctx, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Uploaded || cp.Containers == nil || len(cp.Containers) != 0 {
+		t.Fatalf("expected an empty checkpoint before anything is saved, got %+v", cp)
+	}
+
+	first := &PodCheckpoint{Containers: map[string]*Checkpoint{"main": {Time: "2023-01-01T00:00:05.5Z", Lines: 2}}}
+	if err := store.Save(ctx, "first", first); err != nil {
+		t.Fatal(err)
+	}
+	second := &PodCheckpoint{Uploaded: true}
+	if err := store.Save(ctx, "second", second); err != nil {
+		t.Fatal(err)
+	}
+
+	cm, err := store.Client.CoreV1().ConfigMaps(suite.Namespace).Get(ctx, "checkpoints-log-checkpoints", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cm.OwnerReferences) != 1 || cm.OwnerReferences[0].Name != suite.Name {
+		t.Errorf("expected the configmap to be owned by the suite, got %v", cm.OwnerReferences)
+	}
+
+	cases := []struct {
+		pod      string
+		expected *PodCheckpoint
+	}{
+		{"first", first},
+		{"second", &PodCheckpoint{Containers: map[string]*Checkpoint{}, Uploaded: true}},
+		{"third", &PodCheckpoint{Containers: map[string]*Checkpoint{}}},
+	}
+	for _, c := range cases {
+		cp, err := store.Load(ctx, c.pod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(cp, c.expected) {
+			t.Errorf("%s: expected %+v, got %+v", c.pod, c.expected, cp)
+		}
+	}
+}
+
+func TestTailResumesFromCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	suite := testSuite("resume")
+	last := &Checkpoint{Time: "2023-01-01T00:00:05.5Z", Lines: 2}
+	data, err := json.Marshal(&PodCheckpoint{Containers: map[string]*Checkpoint{"main": last}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kube := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "resume-log-checkpoints", Namespace: suite.Namespace},
+		Data:       map[string]string{"pod": string(data)},
+	})
+
+	pub, client := testPublisher(FlushOptions{})
+	defer pub.Close()
+	w := &LogWatcher{
+		Pod:         testPod("pod"),
+		Step:        &testv1alpha1.StepStatus{Name: "step", PluralId: "step"},
+		Publisher:   pub,
+		Client:      kube,
+		Checkpoints: &CheckpointStore{Client: kube, Suite: suite},
+	}
+	if err := w.Tail(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the stream picks up from the checkpoint's second, and the complete log is refetched for the upload
+	opts := logRequests(kube)
+	if len(opts) != 2 {
+		t.Fatalf("expected a resumed stream and a full refetch, got %d log requests", len(opts))
+	}
+	if opts[0].SinceTime == nil || !opts[0].SinceTime.Equal(last.sinceTime()) || opts[0].SinceSeconds != nil {
+		t.Errorf("expected the stream to resume from the checkpoint, got %+v", opts[0])
+	}
+	if opts[1].SinceTime != nil || opts[1].Follow {
+		t.Errorf("expected the full log to be refetched, got %+v", opts[1])
+	}
+	if uploads := client.Calls(plural.MethodUpdateStep); len(uploads) != 1 {
+		t.Errorf("expected a single log upload, got %d", len(uploads))
+	}
+
+	// the fake stream's line has no timestamp, so the container stays at its previous checkpoint
+	saved, err := w.Checkpoints.Load(ctx, "pod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &PodCheckpoint{Containers: map[string]*Checkpoint{"main": last}, Uploaded: true}
+	if !reflect.DeepEqual(saved, expected) {
+		t.Errorf("expected checkpoint %+v, got %+v", expected, saved)
+	}
+
+	// uploaded pods are never tailed again
+	kube.ClearActions()
+	if err := w.Tail(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if opts := logRequests(kube); len(opts) != 0 {
+		t.Errorf("expected an uploaded pod not to be streamed, got %d log requests", len(opts))
+	}
+}
+
+func logRequests(kube *fake.Clientset) []*corev1.PodLogOptions {
+	res := make([]*corev1.PodLogOptions, 0)
+	for _, action := range kube.Actions() {
+		if action.GetSubresource() != "log" {
+			continue
+		}
+		if opts, ok := action.(k8stesting.GenericAction).GetValue().(*corev1.PodLogOptions); ok {
+			res = append(res, opts)
+		}
+	}
+	return res
+}
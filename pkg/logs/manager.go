@@ -3,32 +3,54 @@ package logs
 import (
 	"context"
 	"fmt"
+	"sync"
+
 	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
 	"github.com/pluralsh/test-harness/pkg/plural"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
 )
 
+// SuiteManager tails the logs of a single suite's pods.  It keeps its own copy of the suite, so it's unaffected
+// by the reconciler mutating the object it was created from.
 type SuiteManager struct {
-	Test      *testv1alpha1.TestSuite
-	Pods      map[string]*LogWatcher
-	Ctx       context.Context
-	Publisher *LogPublisher
-	Cancel    context.CancelFunc
+	mu          sync.Mutex
+	closed      bool
+	Test        *testv1alpha1.TestSuite
+	Pods        map[string]*LogWatcher
+	Ctx         context.Context
+	Publisher   *LogPublisher
+	Checkpoints *CheckpointStore
+	Kube        kubernetes.Interface
+	Cancel      context.CancelFunc
 }
 
+// LogManager keeps a SuiteManager per running suite, and is safe to share between concurrent reconciles
 type LogManager struct {
+	mu     sync.Mutex
 	Config *plural.Config
+	Client plural.Api
+	Socket *plural.Socket
+	// built from the local kubeconfig on first use if unset
+	Kube kubernetes.Interface
+	// when lines buffered for the api are delivered
+	Flush  FlushOptions
 	Suites map[string]*SuiteManager
 }
 
 func NewManager(config *plural.Config) *LogManager {
 	return &LogManager{
 		Config: config,
+		Client: plural.NewClient(config),
+		Socket: plural.NewSocket(config),
+		Flush:  DefaultFlushOptions,
 		Suites: make(map[string]*SuiteManager),
 	}
 }
 
 func (mgr *LogManager) SuiteManager(test *testv1alpha1.TestSuite) (smgr *SuiteManager, err error, found bool) {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
 	name := mgr.name(test)
 	if ssmgr, ok := mgr.Suites[name]; ok {
 		smgr = ssmgr
@@ -36,37 +58,74 @@ func (mgr *LogManager) SuiteManager(test *testv1alpha1.TestSuite) (smgr *SuiteMa
 		return
 	}
 
-	smgr = &SuiteManager{Test: test, Pods: make(map[string]*LogWatcher)}
+	if mgr.Kube == nil {
+		if mgr.Kube, err = kubeClient(); err != nil {
+			return
+		}
+	}
+
+	test = test.DeepCopy()
+	smgr = &SuiteManager{Test: test, Pods: make(map[string]*LogWatcher), Kube: mgr.Kube}
+	smgr.Checkpoints = &CheckpointStore{Client: mgr.Kube, Suite: test}
 	smgr.Ctx, smgr.Cancel = context.WithCancel(context.Background())
 	smgr.Publisher = NewPublisher(mgr, test)
-	smgr.Test = test
 	mgr.Suites[name] = smgr
 	return
 }
 
 func (mgr *LogManager) Cancel(test *testv1alpha1.TestSuite) error {
+	mgr.mu.Lock()
 	name := mgr.name(test)
 	smgr, ok := mgr.Suites[name]
+	if ok {
+		delete(mgr.Suites, name)
+	}
+	mgr.mu.Unlock()
 	if !ok {
 		return fmt.Errorf("No manager found for %s", name)
 	}
 
-	smgr.Cancel()
-	delete(mgr.Suites, name)
-	smgr.Publisher.Wait.Wait()
-	return smgr.Publisher.Close()
+	return smgr.close()
 }
 
 func (mgr *LogManager) name(test *testv1alpha1.TestSuite) string {
 	return fmt.Sprintf("%s:%s", test.Namespace, test.Name)
 }
 
-func (mgr *SuiteManager) AddWatcher(pod *corev1.Pod, step *testv1alpha1.StepStatus) {
-	if _, ok := mgr.Pods[pod.Name]; ok {
+func (mgr *SuiteManager) AddWatcher(pod *corev1.Pod, step *testv1alpha1.StepStatus, sel *testv1alpha1.LogSelection) {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	if _, ok := mgr.Pods[pod.Name]; ok || mgr.closed {
 		return
 	}
 
-	watcher := &LogWatcher{Pod: pod, Step: step, Publisher: mgr.Publisher}
+	// the step status belongs to the reconciler's copy of the suite, which keeps changing after this returns
+	watcher := &LogWatcher{
+		Pod:         pod.DeepCopy(),
+		Step:        step.DeepCopy(),
+		Selection:   sel.DeepCopy(),
+		Publisher:   mgr.Publisher,
+		Client:      mgr.Kube,
+		Checkpoints: mgr.Checkpoints,
+	}
 	mgr.Pods[pod.Name] = watcher
-	go watcher.Tail(mgr.Ctx)
+	mgr.Publisher.Wait.Add(1)
+	go func() {
+		defer mgr.Publisher.Wait.Done()
+		if err := watcher.Tail(mgr.Ctx); err != nil {
+			watcher.log().Error(err, "failed to tail logs")
+		}
+	}()
+}
+
+// close stops every watcher and waits for them to upload their logs before flushing the publisher, no watchers
+// can be added afterwards
+func (mgr *SuiteManager) close() error {
+	mgr.mu.Lock()
+	mgr.closed = true
+	mgr.mu.Unlock()
+
+	mgr.Cancel()
+	mgr.Publisher.Wait.Wait()
+	return mgr.Publisher.Close()
 }
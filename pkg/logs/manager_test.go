@@ -0,0 +1,127 @@
+package logs
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
+	"github.com/pluralsh/test-harness/pkg/plural"
+	pluralfake "github.com/pluralsh/test-harness/pkg/plural/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// these are mostly useful run with -race, the fake clientset serves a single line for every log stream
+
+func testManager() (*LogManager, *pluralfake.Client) {
+	client := pluralfake.NewClient()
+	mgr := &LogManager{
+		Client: client,
+		Kube:   fake.NewSimpleClientset(),
+		Suites: make(map[string]*SuiteManager),
+	}
+	return mgr, client
+}
+
+func testSuite(name string) *testv1alpha1.TestSuite {
+	return &testv1alpha1.TestSuite{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Status:     testv1alpha1.TestSuiteStatus{PluralId: "test-" + name},
+	}
+}
+
+func testPod(name string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "main"}}},
+	}
+}
+
+func TestConcurrentSuites(t *testing.T) {
+	mgr, client := testManager()
+	suites, pods := 5, 4
+	// every pod uploads a single archive of its log files
+	uploads := suites * pods
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < suites; i++ {
+		suite := testSuite(fmt.Sprintf("suite-%d", i))
+		for j := 0; j < pods; j++ {
+			wg.Add(1)
+			go func(j int) {
+				defer wg.Done()
+				smgr, err, _ := mgr.SuiteManager(suite)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				step := &testv1alpha1.StepStatus{PluralId: fmt.Sprintf("%s-step-%d", suite.Name, j)}
+				smgr.AddWatcher(testPod(fmt.Sprintf("%s-pod-%d", suite.Name, j)), step, nil)
+				if err := smgr.Publisher.Publish(&LogRecord{Container: "main", Line: "line"}, step); err != nil {
+					t.Error(err)
+				}
+			}(j)
+		}
+	}
+	wg.Wait()
+
+	// the fake log streams end straight away, so every watcher uploads without waiting to be cancelled
+	deadline := time.Now().Add(10 * time.Second)
+	for len(client.Calls(plural.MethodUpdateStep)) < uploads && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for i := 0; i < suites; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := mgr.Cancel(testSuite(fmt.Sprintf("suite-%d", i))); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(mgr.Suites) != 0 {
+		t.Errorf("expected every suite manager to be removed, found %d", len(mgr.Suites))
+	}
+	if calls := client.Calls(plural.MethodUpdateStep); len(calls) != uploads {
+		t.Errorf("expected %d log uploads, found %d", uploads, len(calls))
+	}
+}
+
+func TestAddWatcherDuringCancel(t *testing.T) {
+	mgr, _ := testManager()
+	suite := testSuite("cancelled")
+	smgr, err, _ := mgr.SuiteManager(suite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wg := &sync.WaitGroup{}
+	for j := 0; j < 10; j++ {
+		wg.Add(1)
+		go func(j int) {
+			defer wg.Done()
+			step := &testv1alpha1.StepStatus{PluralId: fmt.Sprintf("step-%d", j)}
+			smgr.AddWatcher(testPod(fmt.Sprintf("pod-%d", j)), step, nil)
+			_ = smgr.Publisher.Publish(&LogRecord{Container: "main", Line: "line"}, step)
+		}(j)
+	}
+
+	if err := mgr.Cancel(suite); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	smgr.AddWatcher(testPod("late"), &testv1alpha1.StepStatus{PluralId: "late"}, nil)
+	smgr.mu.Lock()
+	defer smgr.mu.Unlock()
+	if _, ok := smgr.Pods["late"]; ok {
+		t.Error("expected watchers added after cancel to be ignored")
+	}
+}
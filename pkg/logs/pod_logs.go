@@ -3,68 +3,85 @@ package logs
 import (
 	"bufio"
 	"context"
-	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
 	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
 	"github.com/pluralsh/test-harness/pkg/utils"
 	"github.com/sethvargo/go-retry"
-	"io"
-	"io/ioutil"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
-	"os"
-	"sync"
-	"time"
+	ctrl "sigs.k8s.io/controller-runtime"
 )
 
+var watcherLog = ctrl.Log.WithName("log-watcher")
+
 type LogWatcher struct {
-	Pod       *corev1.Pod
-	Step      *testv1alpha1.StepStatus
-	Publisher *LogPublisher
+	Pod         *corev1.Pod
+	Step        *testv1alpha1.StepStatus
+	Publisher   *LogPublisher
+	Client      kubernetes.Interface
+	Checkpoints *CheckpointStore
+	// the step's choice of containers to capture, just main if unset
+	Selection *testv1alpha1.LogSelection
+
+	mu      sync.Mutex
+	resume  *PodCheckpoint
+	cursors map[string]*Checkpoint
 }
 
 const (
-	sinceSeconds int64 = 60 * 60 * 24
+	sinceSeconds  int64 = 60 * 60 * 24
+	uploadTimeout       = 5 * time.Minute
+	mainContainer       = "main"
+	allContainers       = "*"
+	// tekton names the container for each step of a task after the step, with this prefix
+	tektonStepPrefix = "step-"
+	tektonTaskLabel  = "tekton.dev/pipelineTask"
 )
 
 func (w *LogWatcher) Tail(ctx context.Context) error {
-	config, err := utils.KubeConfig()
+	resume, err := w.Checkpoints.Load(ctx, w.Pod.Name)
 	if err != nil {
 		return err
 	}
-
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return err
+	if resume.Uploaded {
+		return nil
 	}
+	w.resume = resume
+	w.cursors = map[string]*Checkpoint{}
 
-	f, err := ioutil.TempFile("", w.Pod.Name)
+	files, err := newLogFiles(w.Pod.Name)
 	if err != nil {
 		return err
 	}
-	defer os.Remove(f.Name())
+	defer files.remove()
 
 	wg := &sync.WaitGroup{}
 	functionList := []func(){}
-	for _, container := range w.Pod.Spec.Containers {
+	for _, container := range w.containers() {
+		// streams resume from the last checkpoint, with lines that were already delivered skipped
+		container := container
+		last := resume.Containers[container]
+		cursor := &Checkpoint{}
+		w.cursors[container] = cursor
 		podLogOpts := &corev1.PodLogOptions{
-			Follow:       true,
-			SinceSeconds: utils.Int64(sinceSeconds),
-			Container:    container.Name,
+			Follow:     true,
+			Container:  container,
+			Timestamps: true,
+		}
+		if since := last.sinceTime(); since != nil {
+			podLogOpts.SinceTime = since
+		} else {
+			podLogOpts.SinceSeconds = utils.Int64(sinceSeconds)
 		}
 
-		var podLogs io.ReadCloser
-		backoff := retry.NewExponential(1 * time.Second)
-		backoff = retry.WithMaxRetries(10, backoff)
-		backoff = retry.WithJitterPercent(5, backoff)
-		if err := retry.Do(ctx, backoff, func(ctx context.Context) error {
-			logs, err := clientset.CoreV1().Pods(w.Pod.Namespace).GetLogs(w.Pod.Name, podLogOpts).Stream(ctx)
-			if err != nil {
-				fmt.Println("Failed to tail pod logs", err)
-				return retry.RetryableError(err)
-			}
-			podLogs = logs
-			return nil
-		}); err != nil {
+		podLogs, err := w.stream(ctx, podLogOpts)
+		if err != nil {
 			return err
 		}
 		defer podLogs.Close()
@@ -77,33 +94,244 @@ func (w *LogWatcher) Tail(ctx context.Context) error {
 				case <-ctx.Done():
 					return
 				default:
-					line := reader.Text()
-					f.WriteString(line + "\n")
-					if err := w.Publisher.Publish(line, w.Step); err != nil {
-						fmt.Println("failed to publish line", err)
+					rec := w.record(container, reader.Text())
+					files.write(rec)
+					// only this goroutine moves the cursor, so it can be read without the lock
+					next := *cursor
+					next.advance(rec.Time)
+					if last.covers(&next) {
+						w.advance(cursor, next)
+						continue
+					}
+
+					if err := w.Publisher.Publish(rec, w.Step); err != nil {
+						w.log().Error(err, "failed to publish line", "container", container)
+						continue
 					}
+					w.advance(cursor, next)
 				}
 			}
 		})
 	}
 
-	w.Publisher.Wait.Add(1)
-	defer w.Publisher.Wait.Done()
 	wg.Add(len(functionList))
 	for _, f := range functionList {
 		go f()
 	}
+
+	done := make(chan struct{})
+	go w.checkpointLoop(ctx, done)
 	wg.Wait()
-	fmt.Println("uploading logfile to plural")
-	return w.uploadFile(f)
+	close(done)
+
+	// the tail is cancelled once the suite completes, so the upload can't rely on its context
+	uploadCtx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+	defer cancel()
+	if len(resume.Containers) > 0 {
+		// only lines after the checkpoint were streamed, so refetch the lot for a complete log file
+		if err := w.fullLogs(uploadCtx, files); err != nil {
+			return err
+		}
+	}
+
+	w.log().Info("uploading logfile to plural")
+	if err := w.uploadFiles(files); err != nil {
+		return err
+	}
+	return w.checkpoint(uploadCtx, true)
 }
 
-func (w *LogWatcher) uploadFile(f *os.File) error {
-	stepId := w.Step.PluralId
-	if err := w.Publisher.Client.UpdateStep(stepId, f.Name()); err != nil {
-		fmt.Println("failed to upload logs", err)
+func (w *LogWatcher) stream(ctx context.Context, opts *corev1.PodLogOptions) (io.ReadCloser, error) {
+	var podLogs io.ReadCloser
+	backoff := retry.NewExponential(1 * time.Second)
+	backoff = retry.WithMaxRetries(10, backoff)
+	backoff = retry.WithJitterPercent(5, backoff)
+	err := retry.Do(ctx, backoff, func(ctx context.Context) error {
+		logs, err := w.Client.CoreV1().Pods(w.Pod.Namespace).GetLogs(w.Pod.Name, opts).Stream(ctx)
+		if err != nil {
+			w.log().Error(err, "failed to tail pod logs")
+			return retry.RetryableError(err)
+		}
+		podLogs = logs
+		return nil
+	})
+	return podLogs, err
+}
+
+func (w *LogWatcher) fullLogs(ctx context.Context, files *logFiles) error {
+	if err := files.truncate(); err != nil {
 		return err
 	}
 
+	for _, container := range w.containers() {
+		opts := &corev1.PodLogOptions{Container: container, SinceSeconds: utils.Int64(sinceSeconds), Timestamps: true}
+		podLogs, err := w.stream(ctx, opts)
+		if err != nil {
+			return err
+		}
+
+		reader := bufio.NewScanner(podLogs)
+		for reader.Scan() {
+			files.write(w.record(container, reader.Text()))
+		}
+		podLogs.Close()
+		if err := reader.Err(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// containers picks the pod's containers to capture according to the step's selection, init containers first
+func (w *LogWatcher) containers() []string {
+	sel := w.Selection
+	if sel == nil {
+		sel = &testv1alpha1.LogSelection{}
+	}
+	include := sel.Include
+	if len(include) == 0 {
+		include = []string{mainContainer}
+	}
+
+	res := make([]string, 0)
+	if sel.InitContainers {
+		for _, container := range w.Pod.Spec.InitContainers {
+			if !containsName(sel.Exclude, w.containerName(container.Name)) {
+				res = append(res, container.Name)
+			}
+		}
+	}
+
+	for _, container := range w.Pod.Spec.Containers {
+		name := w.containerName(container.Name)
+		if (containsName(include, allContainers) || containsName(include, name)) && !containsName(sel.Exclude, name) {
+			res = append(res, container.Name)
+		}
+	}
+	return res
+}
+
+// containerName is the name a container was given in the step's template
+func (w *LogWatcher) containerName(name string) string {
+	if _, ok := w.Pod.Labels[tektonTaskLabel]; ok {
+		return strings.TrimPrefix(name, tektonStepPrefix)
+	}
+	return name
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// record wraps a raw line from the kubelet, timestamp included, with where it came from
+func (w *LogWatcher) record(container, raw string) *LogRecord {
+	ts, line := splitTimestamp(raw)
+	return &LogRecord{
+		Time:      ts,
+		Pod:       w.Pod.Name,
+		Container: container,
+		Step:      w.Step.Name,
+		Attempt:   w.attempt(),
+		Line:      line,
+	}
+}
+
+// attempt works out which attempt at the step the pod ran, as steps record one pod per attempt
+func (w *LogWatcher) attempt() int {
+	for i, pod := range w.Step.Pods {
+		if pod == w.Pod.Name {
+			return i + 1
+		}
+	}
+
+	if w.Step.Attempts > 0 {
+		return int(w.Step.Attempts)
+	}
+	return 1
+}
+
+// log tags the watcher's messages with the suite, step and pod they're about
+func (w *LogWatcher) log() logr.Logger {
+	log := watcherLog.WithValues("step", w.Step.Name, "pluralId", w.Step.PluralId, "pod", w.Pod.Name)
+	if w.Checkpoints != nil && w.Checkpoints.Suite != nil {
+		log = log.WithValues("suite", w.Checkpoints.Suite.Name, "namespace", w.Checkpoints.Suite.Namespace)
+	}
+	return log
+}
+
+// advance moves a container's cursor once its line is with the publisher, under the lock checkpoints read it with
+func (w *LogWatcher) advance(cursor *Checkpoint, to Checkpoint) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	*cursor = to
+}
+
+func (w *LogWatcher) checkpointLoop(ctx context.Context, done chan struct{}) {
+	ticker := time.NewTicker(checkpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := w.checkpoint(ctx, false); err != nil {
+				w.log().Error(err, "failed to checkpoint logs")
+			}
+		}
+	}
+}
+
+// checkpoint flushes whatever the publisher has buffered, then records everything handed to it so far as
+// delivered.  The cursors are read before flushing, so the checkpoint never runs ahead of what plural has.
+func (w *LogWatcher) checkpoint(ctx context.Context, uploaded bool) error {
+	w.mu.Lock()
+	cp := &PodCheckpoint{Containers: map[string]*Checkpoint{}, Uploaded: uploaded}
+	for name, cursor := range w.cursors {
+		// a stream still replaying lines from before the last checkpoint hasn't got past it yet
+		last := w.resume.Containers[name]
+		if last.covers(cursor) || cursor.Time == "" {
+			if last != nil {
+				cp.Containers[name] = &Checkpoint{Time: last.Time, Lines: last.Lines}
+			}
+			continue
+		}
+		cp.Containers[name] = &Checkpoint{Time: cursor.Time, Lines: cursor.Lines}
+	}
+	w.mu.Unlock()
+
+	if err := w.Publisher.Flush(); err != nil {
+		return err
+	}
+	return w.Checkpoints.Save(ctx, w.Pod.Name, cp)
+}
+
+// uploadFiles sends both log files to plural in a single archive, as a step only keeps its latest upload
+func (w *LogWatcher) uploadFiles(files *logFiles) error {
+	archive, err := files.archive(w.Pod.Name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive)
+
+	if err := w.Publisher.Client.UpdateStep(w.Step.PluralId, archive); err != nil {
+		w.log().Error(err, "failed to upload logs")
+		return err
+	}
+	return nil
+}
+
+func kubeClient() (kubernetes.Interface, error) {
+	config, err := utils.KubeConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return kubernetes.NewForConfig(config)
+}
@@ -0,0 +1,145 @@
+package logs
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
+	"github.com/pluralsh/test-harness/pkg/plural"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestContainerSelection(t *testing.T) {
+	argoPod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "init"}},
+			Containers:     []corev1.Container{{Name: "wait"}, {Name: "main"}, {Name: "proxy"}},
+		},
+	}
+	tektonPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{tektonTaskLabel: "first"}},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "prepare"}},
+			Containers:     []corev1.Container{{Name: "step-main"}},
+		},
+	}
+
+	cases := []struct {
+		name     string
+		pod      *corev1.Pod
+		sel      *testv1alpha1.LogSelection
+		expected []string
+	}{
+		{"defaults to main", argoPod, nil, []string{"main"}},
+		{"includes init containers", argoPod, &testv1alpha1.LogSelection{InitContainers: true}, []string{"init", "main"}},
+		{"includes by name", argoPod, &testv1alpha1.LogSelection{Include: []string{"main", "proxy"}}, []string{"main", "proxy"}},
+		{"excludes from everything", argoPod, &testv1alpha1.LogSelection{Include: []string{"*"}, Exclude: []string{"wait"}}, []string{"main", "proxy"}},
+		{"matches tekton step containers", tektonPod, nil, []string{"step-main"}},
+	}
+
+	for _, c := range cases {
+		w := &LogWatcher{Pod: c.pod, Selection: c.sel}
+		if containers := w.containers(); !reflect.DeepEqual(containers, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, containers)
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	w := &LogWatcher{
+		Pod:  &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "first-retry"}},
+		Step: &testv1alpha1.StepStatus{Name: "first", Pods: []string{"first", "first-retry"}},
+	}
+
+	rec := w.record("main", "2023-01-01T00:00:00.5Z hello world")
+	expected := &LogRecord{Time: "2023-01-01T00:00:00.5Z", Pod: "first-retry", Container: "main", Step: "first", Attempt: 2, Line: "hello world"}
+	if !reflect.DeepEqual(rec, expected) {
+		t.Errorf("expected %+v, got %+v", expected, rec)
+	}
+	if rec.String() != "2023-01-01T00:00:00.5Z [main] hello world" {
+		t.Errorf("unexpected text rendering %q", rec.String())
+	}
+}
+
+func TestUploadFiles(t *testing.T) {
+	pub, client := testPublisher(FlushOptions{})
+	defer pub.Close()
+	w := &LogWatcher{
+		Pod:       testPod("pod"),
+		Step:      &testv1alpha1.StepStatus{Name: "step", PluralId: "step"},
+		Publisher: pub,
+	}
+
+	files, err := newLogFiles("pod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer files.remove()
+	records := []*LogRecord{
+		w.record("main", "2023-01-01T00:00:00.5Z hello"),
+		w.record("sidecar", "2023-01-01T00:00:01Z world"),
+	}
+	for _, rec := range records {
+		if err := files.write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.uploadFiles(files); err != nil {
+		t.Fatal(err)
+	}
+	calls := client.Calls(plural.MethodUpdateStep)
+	if len(calls) != 1 {
+		t.Fatalf("expected a single upload, got %d", len(calls))
+	}
+
+	// both files survive in the step's one attachment, and the jsonl gives back the records themselves
+	contents := readArchive(t, calls[0].Logs)
+	if text := contents["pod.log"]; text != "2023-01-01T00:00:00.5Z [main] hello\n2023-01-01T00:00:01Z [sidecar] world\n" {
+		t.Errorf("unexpected text log %q", text)
+	}
+	uploaded := make([]*LogRecord, 0)
+	scanner := bufio.NewScanner(strings.NewReader(contents["pod.jsonl"]))
+	for scanner.Scan() {
+		var rec LogRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		uploaded = append(uploaded, &rec)
+	}
+	if !reflect.DeepEqual(uploaded, records) {
+		t.Errorf("expected the jsonl to hold %+v, got %+v", records, uploaded)
+	}
+}
+
+func readArchive(t *testing.T, archive string) map[string]string {
+	t.Helper()
+	gz, err := gzip.NewReader(strings.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return res
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res[hdr.Name] = string(contents)
+	}
+}
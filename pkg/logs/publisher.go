@@ -4,71 +4,257 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
-	phx "github.com/Douvi/gophoenix"
 	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
 	"github.com/pluralsh/test-harness/pkg/plural"
+	ctrl "sigs.k8s.io/controller-runtime"
 )
 
+var publisherLog = ctrl.Log.WithName("log-publisher")
+
 type LogPublisher struct {
-	mu      sync.Mutex
-	Client  *plural.Client
+	// guards the buffers, it's never held across a call to plural
+	mu sync.Mutex
+	// serializes deliveries, so batches and pushed lines reach plural in the order they were published
+	sending sync.Mutex
+	Client  plural.Api
 	Test    *testv1alpha1.TestSuite
-	Channel *phx.Channel
-	Buffer  map[string][]string
+	Channel *plural.Channel
+	Buffer  map[string]*StepBuffer
+	Options FlushOptions
 	Wait    *sync.WaitGroup
+	stop    chan struct{}
+	once    sync.Once
+}
+
+// StepBuffer holds the lines of a step waiting to be delivered over the api
+type StepBuffer struct {
+	Lines []string
+	Bytes int
+	// when the oldest line in the buffer arrived
+	Since time.Time
+}
+
+// FlushOptions bound how long lines wait in a step's buffer before they're delivered over the api.  Buffers are
+// delivered as soon as any threshold is hit, so chatty steps go out in large batches while quiet ones still show
+// up promptly.
+type FlushOptions struct {
+	// the longest a line is buffered for
+	MaxLatency time.Duration
+	// the most lines buffered per step
+	MaxLines int
+	// the most bytes of log lines buffered per step
+	MaxBytes int
 }
 
 type LogMessage struct {
-	Line string `json:"line"`
-	Id   string `json:"step"`
+	Line   string     `json:"line"`
+	Id     string     `json:"step"`
+	Record *LogRecord `json:"record"`
 }
 
-const flushLen = 10
+const logEvent = "log"
 
+var DefaultFlushOptions = FlushOptions{
+	MaxLatency: 2 * time.Second,
+	MaxLines:   500,
+	MaxBytes:   64 * 1024,
+}
+
+// NewPublisher streams lines over the test's socket channel when it's joined, falling back to batching them
+// through the graphql api whenever it isn't
 func NewPublisher(mgr *LogManager, test *testv1alpha1.TestSuite) *LogPublisher {
-	return &LogPublisher{
-		Client: plural.NewClient(mgr.Config),
-		Test:   test,
-		Buffer: make(map[string][]string),
-		Wait:   &sync.WaitGroup{},
+	pub := &LogPublisher{
+		Client:  mgr.Client,
+		Test:    test,
+		Buffer:  make(map[string]*StepBuffer),
+		Options: mgr.Flush.withDefaults(),
+		Wait:    &sync.WaitGroup{},
+		stop:    make(chan struct{}),
+	}
+	go pub.flushLoop()
+
+	if mgr.Socket != nil {
+		mgr.Socket.Start()
+		ch, err := mgr.Socket.Join(pub, testTopic(test))
+		if err != nil {
+			publisherLog.Error(err, "failed to join log channel", "topic", testTopic(test))
+		}
+		pub.Channel = ch
+	}
+	return pub
+}
+
+// Publish sends a record to plural, in full over the socket, or rendered as a line of text over the api
+func (pub *LogPublisher) Publish(rec *LogRecord, step *testv1alpha1.StepStatus) error {
+	id := step.PluralId
+	if pub.Channel != nil && pub.Channel.Joined() {
+		if pushed, err := pub.push(id, rec); pushed || err != nil {
+			return err
+		}
+	}
+
+	if pub.buffer(id, rec) {
+		return pub.deliver(id)
 	}
+	return nil
 }
 
-func (pub *LogPublisher) Publish(line string, step *testv1alpha1.StepStatus) error {
-	fmt.Printf("Publishing %s\n", line)
+// push streams a record over the socket, reporting whether it went out so the caller can fall back to the api
+func (pub *LogPublisher) push(id string, rec *LogRecord) (bool, error) {
+	pub.sending.Lock()
+	defer pub.sending.Unlock()
+
+	// anything buffered while the socket was down has to go out first to keep lines in order
+	if err := pub.send(id); err != nil {
+		// keep the line behind the backlog so it isn't dropped or pushed out of order
+		pub.buffer(id, rec)
+		return false, err
+	}
+
+	if err := pub.Channel.Push(logEvent, &LogMessage{Line: rec.Line, Id: id, Record: rec}); err != nil {
+		publisherLog.Error(err, "failed to push line, falling back to the api", "step", id)
+		return false, nil
+	}
+	return true, nil
+}
+
+// buffer queues a record's line for delivery over the api, reporting whether the step's buffer is full
+func (pub *LogPublisher) buffer(id string, rec *LogRecord) bool {
 	pub.mu.Lock()
 	defer pub.mu.Unlock()
-	id := step.PluralId
 	buf, ok := pub.Buffer[id]
 	if !ok {
-		buf = make([]string, 0, flushLen)
+		buf = &StepBuffer{}
+		pub.Buffer[id] = buf
 	}
-	pub.Buffer[id] = append(buf, line)
+	if len(buf.Lines) == 0 {
+		buf.Since = time.Now()
+	}
+	line := rec.String()
+	buf.Lines = append(buf.Lines, line)
+	buf.Bytes += len(line) + 1
+	return len(buf.Lines) >= pub.Options.MaxLines || buf.Bytes >= pub.Options.MaxBytes
+}
 
-	if len(pub.Buffer[id]) >= flushLen {
-		return pub.deliver(id)
+// Flush delivers every buffered line
+func (pub *LogPublisher) Flush() error {
+	return pub.flush(time.Time{})
+}
+
+func (pub *LogPublisher) Close() error {
+	pub.once.Do(func() { close(pub.stop) })
+	if err := pub.Flush(); err != nil {
+		return err
 	}
 
+	if pub.Channel != nil {
+		return pub.Channel.Leave()
+	}
 	return nil
 }
 
-func (pub *LogPublisher) Close() error {
-	for id := range pub.Buffer {
-		if len(pub.Buffer[id]) > 0 {
-			if err := pub.deliver(id); err != nil {
-				return err
+func (pub *LogPublisher) OnJoin(payload interface{}) {
+	publisherLog.Info("joined log channel", "topic", testTopic(pub.Test))
+}
+
+func (pub *LogPublisher) OnJoinError(payload interface{}) {
+	publisherLog.Info("failed to join log channel", "topic", testTopic(pub.Test), "payload", payload)
+}
+
+func (pub *LogPublisher) OnChannelClose(payload interface{}) {
+	publisherLog.Info("log channel closed", "topic", testTopic(pub.Test), "payload", payload)
+}
+
+func (pub *LogPublisher) OnMessage(ref int64, event string, payload interface{}) {}
+
+// flushLoop delivers buffers whose oldest line has waited out the max latency, until the publisher is closed
+func (pub *LogPublisher) flushLoop() {
+	ticker := time.NewTicker(pub.Options.MaxLatency / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-pub.stop:
+			return
+		case <-ticker.C:
+			if err := pub.flush(time.Now().Add(-pub.Options.MaxLatency)); err != nil {
+				publisherLog.Error(err, "failed to flush logs", "topic", testTopic(pub.Test))
 			}
 		}
 	}
+}
 
+// flush delivers the buffers that started filling before the cutoff, a zero cutoff delivers them all
+func (pub *LogPublisher) flush(cutoff time.Time) error {
+	pub.mu.Lock()
+	ids := make([]string, 0, len(pub.Buffer))
+	for id, buf := range pub.Buffer {
+		if len(buf.Lines) == 0 || (!cutoff.IsZero() && buf.Since.After(cutoff)) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	pub.mu.Unlock()
+
+	for _, id := range ids {
+		if err := pub.deliver(id); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// deliver sends whatever is buffered for a step over the api
 func (pub *LogPublisher) deliver(id string) error {
-	buf, _ := pub.Buffer[id]
-	logs := strings.Join(buf, "\n")
-	pub.Buffer[id] = make([]string, 0, flushLen)
-	fmt.Println("publishing log batch for ", id)
-	return pub.Client.PublishLogs(id, logs)
+	pub.sending.Lock()
+	defer pub.sending.Unlock()
+	return pub.send(id)
+}
+
+// send swaps a step's buffer out and delivers it once the lock is released, the sending lock must be held
+func (pub *LogPublisher) send(id string) error {
+	pub.mu.Lock()
+	buf, ok := pub.Buffer[id]
+	if !ok || len(buf.Lines) == 0 {
+		pub.mu.Unlock()
+		return nil
+	}
+	pub.Buffer[id] = &StepBuffer{}
+	pub.mu.Unlock()
+
+	publisherLog.V(1).Info("publishing log batch", "step", id, "lines", len(buf.Lines))
+	if err := pub.Client.PublishLogs(id, strings.Join(buf.Lines, "\n")); err != nil {
+		pub.requeue(id, buf)
+		return err
+	}
+	return nil
+}
+
+// requeue puts a batch that failed to go out back in front of whatever was buffered for the step since
+func (pub *LogPublisher) requeue(id string, batch *StepBuffer) {
+	pub.mu.Lock()
+	defer pub.mu.Unlock()
+	if buf, ok := pub.Buffer[id]; ok {
+		batch.Lines = append(batch.Lines, buf.Lines...)
+		batch.Bytes += buf.Bytes
+	}
+	pub.Buffer[id] = batch
+}
+
+func (opts FlushOptions) withDefaults() FlushOptions {
+	if opts.MaxLatency <= 0 {
+		opts.MaxLatency = DefaultFlushOptions.MaxLatency
+	}
+	if opts.MaxLines <= 0 {
+		opts.MaxLines = DefaultFlushOptions.MaxLines
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultFlushOptions.MaxBytes
+	}
+	return opts
+}
+
+func testTopic(test *testv1alpha1.TestSuite) string {
+	return fmt.Sprintf("tests:%s", test.Status.PluralId)
 }
@@ -0,0 +1,218 @@
+package logs
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	testv1alpha1 "github.com/pluralsh/test-harness/api/v1alpha1"
+	"github.com/pluralsh/test-harness/pkg/plural"
+	"github.com/pluralsh/test-harness/pkg/plural/fake"
+)
+
+func testPublisher(opts FlushOptions) (*LogPublisher, *fake.Client) {
+	client := fake.NewClient()
+	mgr := &LogManager{Client: client, Flush: opts}
+	return NewPublisher(mgr, testSuite("publisher")), client
+}
+
+func TestPublisherFlushesAfterLatency(t *testing.T) {
+	pub, client := testPublisher(FlushOptions{MaxLatency: 100 * time.Millisecond})
+	defer pub.Close()
+	step := &testv1alpha1.StepStatus{PluralId: "step"}
+	for _, line := range []string{"one", "two", "three"} {
+		if err := pub.Publish(&LogRecord{Container: "main", Line: line}, step); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(client.Calls(plural.MethodPublishLogs)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	calls := client.Calls(plural.MethodPublishLogs)
+	if len(calls) != 1 || calls[0].Logs != "[main] one\n[main] two\n[main] three" {
+		t.Fatalf("expected the buffered lines to be delivered in one batch, got %v", calls)
+	}
+}
+
+func TestPublisherFlushesOnSize(t *testing.T) {
+	pub, client := testPublisher(FlushOptions{MaxLatency: time.Hour, MaxLines: 3, MaxBytes: 40})
+	defer pub.Close()
+	step := &testv1alpha1.StepStatus{PluralId: "step"}
+	for _, line := range []string{"a", "b", "c", strings.Repeat("x", 40)} {
+		if err := pub.Publish(&LogRecord{Container: "main", Line: line}, step); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	calls := client.Calls(plural.MethodPublishLogs)
+	if len(calls) != 2 || calls[0].Logs != "[main] a\n[main] b\n[main] c" || calls[1].Logs != "[main] "+strings.Repeat("x", 40) {
+		t.Fatalf("expected a batch per threshold hit, got %v", calls)
+	}
+}
+
+func TestPublisherFallsBackToTheApi(t *testing.T) {
+	srv := fake.NewServer("token")
+	defer srv.Close()
+	retries := uint64(0)
+	conf := srv.Config()
+	conf.MaxRetries = &retries
+	socket := plural.NewSocket(conf)
+	defer socket.Close()
+
+	test := testSuite("fallback")
+	pub := NewPublisher(&LogManager{Client: plural.NewClient(conf), Socket: socket}, test)
+	defer pub.Close()
+	step := &testv1alpha1.StepStatus{PluralId: "step"}
+	eventually := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	eventually("the log channel to be joined", pub.Channel.Joined)
+	if err := pub.Publish(&LogRecord{Container: "main", Line: "streamed"}, step); err != nil {
+		t.Fatal(err)
+	}
+	eventually("the line to be pushed", func() bool { return len(srv.Messages(testTopic(test))) == 1 })
+
+	srv.RefuseSockets(true)
+	srv.DisconnectSockets()
+	eventually("the log channel to drop", func() bool { return !pub.Channel.Joined() })
+	if err := pub.Publish(&LogRecord{Container: "main", Line: "batched"}, step); err != nil {
+		t.Fatal(err)
+	}
+	if err := pub.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	calls := srv.Calls(plural.MethodPublishLogs)
+	if len(calls) != 1 || calls[0].Logs != "[main] batched" {
+		t.Fatalf("expected the line to go through the api while the socket is down, got %v", calls)
+	}
+
+	srv.RefuseSockets(false)
+	eventually("the log channel to be rejoined", pub.Channel.Joined)
+	if err := pub.Publish(&LogRecord{Container: "main", Line: "streamed again"}, step); err != nil {
+		t.Fatal(err)
+	}
+	eventually("the line to be pushed after reconnecting", func() bool { return len(srv.Messages(testTopic(test))) == 2 })
+}
+
+func TestPublisherKeepsFailedBatches(t *testing.T) {
+	pub, client := testPublisher(FlushOptions{MaxLatency: time.Hour})
+	defer pub.Close()
+	step := &testv1alpha1.StepStatus{PluralId: "step"}
+	if err := pub.Publish(&LogRecord{Container: "main", Line: "first"}, step); err != nil {
+		t.Fatal(err)
+	}
+
+	client.Fail(plural.MethodPublishLogs, errors.New("unavailable"))
+	if err := pub.Flush(); err == nil {
+		t.Fatal("expected the flush to fail")
+	}
+	if err := pub.Publish(&LogRecord{Container: "main", Line: "second"}, step); err != nil {
+		t.Fatal(err)
+	}
+
+	client.Fail(plural.MethodPublishLogs, nil)
+	failed := len(client.Calls(plural.MethodPublishLogs))
+	if err := pub.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	calls := client.Calls(plural.MethodPublishLogs)[failed:]
+	if len(calls) != 1 || calls[0].Logs != "[main] first\n[main] second" {
+		t.Fatalf("expected the failed batch to go out ahead of later lines, got %v", calls)
+	}
+}
+
+func TestPublisherKeepsLinesWhenTheBacklogFails(t *testing.T) {
+	srv := fake.NewServer("token")
+	defer srv.Close()
+	retries := uint64(0)
+	conf := srv.Config()
+	conf.MaxRetries = &retries
+	socket := plural.NewSocket(conf)
+	defer socket.Close()
+
+	test := testSuite("backlog")
+	pub := NewPublisher(&LogManager{Client: plural.NewClient(conf), Socket: socket, Flush: FlushOptions{MaxLatency: time.Hour}}, test)
+	defer pub.Close()
+	step := &testv1alpha1.StepStatus{PluralId: "step"}
+
+	// buffer a line as if the socket had been down, then fail delivering it once the channel is back
+	pub.buffer(step.PluralId, &LogRecord{Container: "main", Line: "backlog"})
+	deadline := time.Now().Add(10 * time.Second)
+	for !pub.Channel.Joined() && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	srv.Fail(plural.MethodPublishLogs, errors.New("unavailable"))
+	if err := pub.Publish(&LogRecord{Container: "main", Line: "current"}, step); err == nil {
+		t.Fatal("expected the backlog delivery to fail")
+	}
+	if msgs := srv.Messages(testTopic(test)); len(msgs) != 0 {
+		t.Fatalf("expected nothing to be pushed ahead of the backlog, got %v", msgs)
+	}
+
+	srv.Fail(plural.MethodPublishLogs, nil)
+	failed := len(srv.Calls(plural.MethodPublishLogs))
+	if err := pub.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	calls := srv.Calls(plural.MethodPublishLogs)[failed:]
+	if len(calls) != 1 || calls[0].Logs != "[main] backlog\n[main] current" {
+		t.Fatalf("expected the backlog and the current line to be kept in order, got %v", calls)
+	}
+}
+
+// blockingClient holds publishes until released, to stand in for a slow or retrying api
+type blockingClient struct {
+	*fake.Client
+	started chan struct{}
+	release chan struct{}
+}
+
+func (client *blockingClient) PublishLogs(stepId, logs string) error {
+	client.started <- struct{}{}
+	<-client.release
+	return client.Client.PublishLogs(stepId, logs)
+}
+
+func TestPublisherBuffersDuringDelivery(t *testing.T) {
+	client := &blockingClient{Client: fake.NewClient(), started: make(chan struct{}, 1), release: make(chan struct{})}
+	pub := NewPublisher(&LogManager{Client: client, Flush: FlushOptions{MaxLatency: time.Hour, MaxLines: 1}}, testSuite("slow"))
+	step := &testv1alpha1.StepStatus{PluralId: "step"}
+
+	delivered := make(chan error)
+	go func() { delivered <- pub.Publish(&LogRecord{Container: "main", Line: "slow"}, step) }()
+	<-client.started
+
+	// other steps keep buffering while a batch is stuck on the api
+	buffered := make(chan bool)
+	go func() { buffered <- pub.buffer("other", &LogRecord{Container: "main", Line: "fast"}) }()
+	select {
+	case <-buffered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("buffering blocked on an in flight delivery")
+	}
+
+	close(client.release)
+	if err := <-delivered; err != nil {
+		t.Fatal(err)
+	}
+	if err := pub.Close(); err != nil {
+		t.Fatal(err)
+	}
+	calls := client.Calls(plural.MethodPublishLogs)
+	if len(calls) != 2 || calls[0].Logs != "[main] slow" || calls[1].Logs != "[main] fast" {
+		t.Fatalf("expected both batches to be delivered in order, got %v", calls)
+	}
+}
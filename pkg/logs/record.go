@@ -0,0 +1,131 @@
+package logs
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// LogRecord is a single log line along with where it came from
+type LogRecord struct {
+	// the kubelet's timestamp for the line, if it had one
+	Time      string `json:"time,omitempty"`
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	Step      string `json:"step"`
+	// which attempt at the step the pod ran, starting from 1
+	Attempt int    `json:"attempt"`
+	Line    string `json:"line"`
+}
+
+// String renders the record the way it appears in the human readable log file
+func (rec *LogRecord) String() string {
+	var b strings.Builder
+	if rec.Time != "" {
+		b.WriteString(rec.Time)
+		b.WriteString(" ")
+	}
+	fmt.Fprintf(&b, "[%s] %s", rec.Container, rec.Line)
+	return b.String()
+}
+
+// logFiles collects a pod's records into a human readable log file and a jsonl file of the records themselves,
+// which are archived together and uploaded to plural once the pod is done
+type logFiles struct {
+	Text  *os.File
+	JSONL *os.File
+}
+
+func newLogFiles(pod string) (*logFiles, error) {
+	text, err := ioutil.TempFile("", pod+"-*.log")
+	if err != nil {
+		return nil, err
+	}
+
+	jsonl, err := ioutil.TempFile("", pod+"-*.jsonl")
+	if err != nil {
+		text.Close()
+		os.Remove(text.Name())
+		return nil, err
+	}
+	return &logFiles{Text: text, JSONL: jsonl}, nil
+}
+
+// write appends the record to both files, each in a single write so concurrent containers don't interleave
+func (files *logFiles) write(rec *LogRecord) error {
+	if _, err := files.Text.WriteString(rec.String() + "\n"); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = files.JSONL.Write(append(line, '\n'))
+	return err
+}
+
+// archive bundles both files into a gzipped tarball, as <pod>.log and <pod>.jsonl, returning its path
+func (files *logFiles) archive(pod string) (string, error) {
+	f, err := ioutil.TempFile("", pod+"-*.tar.gz")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err := files.writeArchive(f, pod); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func (files *logFiles) writeArchive(f *os.File, pod string) error {
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	entries := []struct {
+		name string
+		src  *os.File
+	}{{pod + ".log", files.Text}, {pod + ".jsonl", files.JSONL}}
+	for _, entry := range entries {
+		contents, err := os.ReadFile(entry.src.Name())
+		if err != nil {
+			return err
+		}
+
+		if err := tw.WriteHeader(&tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(contents))}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(contents); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func (files *logFiles) truncate() error {
+	for _, f := range []*os.File{files.Text, files.JSONL} {
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (files *logFiles) remove() {
+	for _, f := range []*os.File{files.Text, files.JSONL} {
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
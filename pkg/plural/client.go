@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/pluralsh/gqlclient"
+	"golang.org/x/time/rate"
 )
 
 type authedTransport struct {
@@ -22,12 +25,21 @@ func (t *authedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 type Config struct {
 	Token    string
 	Endpoint string
+
+	// requests per second allowed against the api, defaults to 5 with a burst of 10
+	RateLimit float64
+	Burst     int
+
+	// how many times failed requests are retried and the initial backoff between attempts, which doubles each time
+	MaxRetries *uint64
+	RetryBase  time.Duration
 }
 
 type Client struct {
 	ctx          context.Context
 	pluralClient *gqlclient.Client
 	config       *Config
+	limiter      *rate.Limiter
 }
 
 func NewConfig() *Config {
@@ -46,15 +58,41 @@ func NewClient(conf *Config) *Client {
 		},
 	}
 	endpoint := base + "/gql"
+	limit, burst := rate.Limit(defaultRateLimit), defaultBurst
+	if conf.RateLimit > 0 {
+		limit = rate.Limit(conf.RateLimit)
+	}
+	if conf.Burst > 0 {
+		burst = conf.Burst
+	}
+
 	return &Client{
 		ctx:          context.Background(),
 		pluralClient: gqlclient.NewClient(&httpClient, endpoint),
 		config:       conf,
+		limiter:      rate.NewLimiter(limit, burst),
 	}
 }
 
+// BaseUrl is the root url of the plural api.  Endpoints default to https, but can carry an explicit scheme, eg
+// http://localhost:4000 for a local stand-in server.
 func (c *Config) BaseUrl() string {
-	return fmt.Sprintf("https://%s", c.PluralEndpoint())
+	endpoint := strings.TrimSuffix(c.PluralEndpoint(), "/")
+	if strings.HasPrefix(endpoint, "http://") || strings.HasPrefix(endpoint, "https://") {
+		return endpoint
+	}
+
+	return fmt.Sprintf("https://%s", endpoint)
+}
+
+// SocketUrl is the root url of the plural websocket, which is secure unless the api is served over plain http
+func (c *Config) SocketUrl() string {
+	base := c.BaseUrl()
+	if strings.HasPrefix(base, "http://") {
+		return "ws://" + strings.TrimPrefix(base, "http://")
+	}
+
+	return "wss://" + strings.TrimPrefix(base, "https://")
 }
 
 func (c *Config) PluralEndpoint() string {
@@ -0,0 +1,221 @@
+package fake
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/pluralsh/gqlclient"
+	"github.com/pluralsh/test-harness/pkg/plural"
+)
+
+// Call is a single request made against a Client
+type Call struct {
+	Method string
+	Id     string
+	Repo   string
+	Test   *gqlclient.TestAttributes
+	Logs   string
+}
+
+// Client is an in-memory implementation of plural.Api which records every call and keeps the state of the tests
+// created through it.  Errors can be injected per method to exercise failure handling.
+type Client struct {
+	mu     sync.Mutex
+	nextId int
+	calls  []Call
+	errors map[string]error
+
+	// the tests created so far, keyed by id
+	Tests map[string]*plural.Test
+	// the repository each test was created in, keyed by test id
+	Repos map[string]string
+	// the log lines published for each step, keyed by step id
+	Logs map[string][]string
+	// the contents of the last log file uploaded for each step, keyed by step id
+	Uploads map[string]string
+}
+
+var _ plural.Api = &Client{}
+
+func NewClient() *Client {
+	return &Client{
+		errors:  map[string]error{},
+		Tests:   map[string]*plural.Test{},
+		Repos:   map[string]string{},
+		Logs:    map[string][]string{},
+		Uploads: map[string]string{},
+	}
+}
+
+// Fail makes every subsequent call to method return err, pass a nil error to clear it
+func (fake *Client) Fail(method string, err error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err == nil {
+		delete(fake.errors, method)
+		return
+	}
+	fake.errors[method] = err
+}
+
+// Calls returns the recorded calls to method, or every call if method is empty
+func (fake *Client) Calls(method string) []Call {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	res := make([]Call, 0)
+	for _, call := range fake.calls {
+		if method == "" || call.Method == method {
+			res = append(res, call)
+		}
+	}
+	return res
+}
+
+// Test returns a copy of the current state of a test
+func (fake *Client) Test(id string) (*plural.Test, bool) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	test, ok := fake.Tests[id]
+	if !ok {
+		return nil, false
+	}
+	return copyTest(test), true
+}
+
+func (fake *Client) CreateTest(repo string, test gqlclient.TestAttributes) (*plural.Test, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.record(Call{Method: plural.MethodCreateTest, Repo: repo, Test: &test}); err != nil {
+		return nil, err
+	}
+
+	id := fake.id("test")
+	t := &plural.Test{Id: id, Steps: []*plural.TestStep{}}
+	fake.apply(t, test)
+	fake.Tests[id] = t
+	fake.Repos[id] = repo
+	return copyTest(t), nil
+}
+
+func (fake *Client) UpdateTest(id string, test gqlclient.TestAttributes) (*plural.Test, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.record(Call{Method: plural.MethodUpdateTest, Id: id, Test: &test}); err != nil {
+		return nil, err
+	}
+
+	t, ok := fake.Tests[id]
+	if !ok {
+		return nil, fmt.Errorf("could not find test %s", id)
+	}
+
+	fake.apply(t, test)
+	return copyTest(t), nil
+}
+
+func (fake *Client) PublishLogs(stepId, logs string) error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.record(Call{Method: plural.MethodPublishLogs, Id: stepId, Logs: logs}); err != nil {
+		return err
+	}
+
+	fake.Logs[stepId] = append(fake.Logs[stepId], logs)
+	return nil
+}
+
+func (fake *Client) UpdateStep(id string, logFile string) error {
+	contents, err := os.ReadFile(logFile)
+	if err != nil {
+		return err
+	}
+
+	return fake.upload(id, string(contents))
+}
+
+func (fake *Client) upload(id, contents string) error {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if err := fake.record(Call{Method: plural.MethodUpdateStep, Id: id, Logs: contents}); err != nil {
+		return err
+	}
+
+	fake.Uploads[id] = contents
+	return nil
+}
+
+func (fake *Client) record(call Call) error {
+	fake.calls = append(fake.calls, call)
+	return fake.errors[call.Method]
+}
+
+func (fake *Client) id(prefix string) string {
+	fake.nextId++
+	return fmt.Sprintf("%s-%d", prefix, fake.nextId)
+}
+
+// apply updates a test with the given attributes, steps are matched by id and failing that by name
+func (fake *Client) apply(t *plural.Test, attrs gqlclient.TestAttributes) {
+	if attrs.Name != nil {
+		t.Name = *attrs.Name
+	}
+	if attrs.PromoteTag != nil {
+		t.PromoteTag = *attrs.PromoteTag
+	}
+	if attrs.Status != nil {
+		t.Status = plural.Status(*attrs.Status)
+	}
+	if attrs.Tags != nil {
+		t.Tags = make([]string, 0)
+		for _, tag := range attrs.Tags {
+			if tag != nil {
+				t.Tags = append(t.Tags, *tag)
+			}
+		}
+	}
+
+	for _, attr := range attrs.Steps {
+		if attr == nil {
+			continue
+		}
+
+		step := findStep(t, attr)
+		if step == nil {
+			step = &plural.TestStep{Id: fake.id("step"), Status: plural.StatusQueued}
+			t.Steps = append(t.Steps, step)
+		}
+		if attr.Name != nil {
+			step.Name = *attr.Name
+		}
+		if attr.Description != nil {
+			step.Description = *attr.Description
+		}
+		if attr.Status != nil {
+			step.Status = plural.Status(*attr.Status)
+		}
+	}
+}
+
+func findStep(t *plural.Test, attr *gqlclient.TestStepAttributes) *plural.TestStep {
+	for _, step := range t.Steps {
+		if attr.ID != nil && step.Id == *attr.ID {
+			return step
+		}
+		if attr.ID == nil && attr.Name != nil && step.Name == *attr.Name {
+			return step
+		}
+	}
+	return nil
+}
+
+func copyTest(t *plural.Test) *plural.Test {
+	res := *t
+	res.Tags = append([]string{}, t.Tags...)
+	res.Steps = make([]*plural.TestStep, 0, len(t.Steps))
+	for _, step := range t.Steps {
+		s := *step
+		res.Steps = append(res.Steps, &s)
+	}
+	return &res
+}
@@ -0,0 +1,334 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+
+	phx "github.com/Douvi/gophoenix"
+	"github.com/gorilla/websocket"
+	"github.com/pluralsh/gqlclient"
+	"github.com/pluralsh/test-harness/pkg/plural"
+)
+
+var operationRegex = regexp.MustCompile(`^\s*(?:mutation|query)\s+(\w+)`)
+
+// Server is a local stand-in for the plural api serving the test mutations over graphql, including multipart
+// log uploads, and the phoenix socket.  State is kept in a Client, so calls can be asserted on and errors
+// injected the same way as with the fake itself.
+type Server struct {
+	*Client
+	Server *httptest.Server
+	Token  string
+
+	mu       sync.Mutex
+	upgrader websocket.Upgrader
+	sockets  map[*websocket.Conn]*socketConn
+	messages map[string][]phx.Message
+	refuse   bool
+}
+
+type socketConn struct {
+	mu     sync.Mutex
+	conn   *websocket.Conn
+	topics map[string]bool
+}
+
+type gqlRequest struct {
+	Query         string                     `json:"query"`
+	OperationName string                     `json:"operationName"`
+	Variables     map[string]json.RawMessage `json:"variables"`
+}
+
+// NewServer starts a stand-in server accepting the given token, call Close once done with it
+func NewServer(token string) *Server {
+	srv := &Server{
+		Client:   NewClient(),
+		Token:    token,
+		sockets:  map[*websocket.Conn]*socketConn{},
+		messages: map[string][]phx.Message{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/gql", srv.handleGraphql)
+	mux.HandleFunc("/socket/websocket", srv.handleSocket)
+	srv.Server = httptest.NewServer(mux)
+	return srv
+}
+
+// Config points a plural client at the server
+func (srv *Server) Config() *plural.Config {
+	return &plural.Config{Token: srv.Token, Endpoint: srv.Server.URL}
+}
+
+func (srv *Server) Close() {
+	srv.DisconnectSockets()
+	srv.Server.Close()
+}
+
+// Messages returns everything pushed to a socket topic by clients, other than joins and leaves
+func (srv *Server) Messages(topic string) []phx.Message {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return append([]phx.Message{}, srv.messages[topic]...)
+}
+
+// Broadcast sends an event to every socket that has joined the topic
+func (srv *Server) Broadcast(topic, event string, payload interface{}) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for _, sock := range srv.sockets {
+		if !sock.joined(topic) {
+			continue
+		}
+
+		if err := sock.write(phx.Message{Topic: topic, Event: event, Payload: payload}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DisconnectSockets drops every open socket, to simulate the server going away
+func (srv *Server) DisconnectSockets() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for conn := range srv.sockets {
+		conn.Close()
+		delete(srv.sockets, conn)
+	}
+}
+
+// RefuseSockets turns new socket connections away while set, so clients stay disconnected until it's cleared
+func (srv *Server) RefuseSockets(refuse bool) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.refuse = refuse
+}
+
+func (srv *Server) authorized(token string) bool {
+	return srv.Token == "" || token == srv.Token
+}
+
+func (srv *Server) handleGraphql(w http.ResponseWriter, r *http.Request) {
+	if !srv.authorized(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	req, files, err := parseGraphql(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := srv.execute(req, files)
+	if err != nil {
+		writeJSON(w, map[string]interface{}{"data": nil, "errors": []map[string]string{{"message": err.Error()}}})
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{"data": data})
+}
+
+func (srv *Server) execute(req *gqlRequest, files map[string]string) (map[string]interface{}, error) {
+	var id, name, logs string
+	var attrs gqlclient.TestAttributes
+	for key, dest := range map[string]interface{}{"id": &id, "name": &name, "logs": &logs, "attrs": &attrs} {
+		if raw, ok := req.Variables[key]; ok {
+			if err := json.Unmarshal(raw, dest); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	switch operationName(req) {
+	case "CreateTest":
+		test, err := srv.CreateTest(name, attrs)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"createTest": testFragment(test)}, nil
+	case "UpdateTest":
+		test, err := srv.UpdateTest(id, attrs)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"updateTest": testFragment(test)}, nil
+	case "PublishLogs":
+		if err := srv.PublishLogs(id, logs); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"publishLogs": map[string]string{"id": id}}, nil
+	case "UpdateStep":
+		// uploads reference the multipart field holding the file by name
+		contents, ok := files[logs]
+		if !ok {
+			return nil, fmt.Errorf("no upload found for %s", logs)
+		}
+		if err := srv.upload(id, contents); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"updateStep": map[string]string{"id": id}}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported operation %s", operationName(req))
+}
+
+func parseGraphql(r *http.Request) (*gqlRequest, map[string]string, error) {
+	req := &gqlRequest{}
+	files := map[string]string{}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		err := json.NewDecoder(r.Body).Decode(req)
+		return req, files, err
+	}
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return nil, nil, err
+	}
+
+	req.Query = r.FormValue("query")
+	if vars := r.FormValue("variables"); vars != "" {
+		if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for field, headers := range r.MultipartForm.File {
+		for _, header := range headers {
+			f, err := header.Open()
+			if err != nil {
+				return nil, nil, err
+			}
+			contents, err := io.ReadAll(f)
+			f.Close()
+			if err != nil {
+				return nil, nil, err
+			}
+			files[field] = string(contents)
+		}
+	}
+
+	return req, files, nil
+}
+
+func operationName(req *gqlRequest) string {
+	if req.OperationName != "" {
+		return req.OperationName
+	}
+
+	if match := operationRegex.FindStringSubmatch(req.Query); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+func testFragment(test *plural.Test) map[string]interface{} {
+	steps := make([]map[string]interface{}, 0)
+	for _, step := range test.Steps {
+		steps = append(steps, map[string]interface{}{
+			"id":          step.Id,
+			"name":        step.Name,
+			"description": step.Description,
+			"status":      step.Status,
+		})
+	}
+
+	return map[string]interface{}{
+		"id":         test.Id,
+		"name":       test.Name,
+		"status":     test.Status,
+		"promoteTag": test.PromoteTag,
+		"steps":      steps,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func (srv *Server) handleSocket(w http.ResponseWriter, r *http.Request) {
+	if !srv.authorized(r.URL.Query().Get("token")) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	srv.mu.Lock()
+	refuse := srv.refuse
+	srv.mu.Unlock()
+	if refuse {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	conn, err := srv.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	sock := &socketConn{conn: conn, topics: map[string]bool{}}
+	srv.mu.Lock()
+	srv.sockets[conn] = sock
+	srv.mu.Unlock()
+
+	defer func() {
+		srv.mu.Lock()
+		delete(srv.sockets, conn)
+		srv.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		var msg phx.Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+
+		switch phx.Event(msg.Event) {
+		case phx.JoinEvent:
+			sock.join(msg.Topic, true)
+		case phx.LeaveEvent:
+			sock.join(msg.Topic, false)
+		default:
+			if msg.Topic != "phoenix" {
+				srv.mu.Lock()
+				srv.messages[msg.Topic] = append(srv.messages[msg.Topic], msg)
+				srv.mu.Unlock()
+			}
+		}
+
+		reply := phx.Message{
+			Topic:   msg.Topic,
+			Event:   string(phx.ReplyEvent),
+			Payload: map[string]interface{}{"status": "ok", "response": map[string]interface{}{}},
+			Ref:     msg.Ref,
+		}
+		if err := sock.write(reply); err != nil {
+			return
+		}
+	}
+}
+
+func (sock *socketConn) join(topic string, joined bool) {
+	sock.mu.Lock()
+	defer sock.mu.Unlock()
+	sock.topics[topic] = joined
+}
+
+func (sock *socketConn) joined(topic string) bool {
+	sock.mu.Lock()
+	defer sock.mu.Unlock()
+	return sock.topics[topic]
+}
+
+func (sock *socketConn) write(msg phx.Message) error {
+	sock.mu.Lock()
+	defer sock.mu.Unlock()
+	return sock.conn.WriteJSON(msg)
+}
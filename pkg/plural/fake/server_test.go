@@ -0,0 +1,86 @@
+package fake
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pluralsh/gqlclient"
+	"github.com/pluralsh/test-harness/pkg/plural"
+)
+
+func TestServerClient(t *testing.T) {
+	srv := NewServer("token")
+	defer srv.Close()
+
+	retries := uint64(0)
+	conf := srv.Config()
+	conf.MaxRetries = &retries
+	client := plural.NewClient(conf)
+
+	name, step, status := "suite", "first", gqlclient.TestStatusQueued
+	test, err := client.CreateTest("repo", gqlclient.TestAttributes{
+		Name:   &name,
+		Status: &status,
+		Steps:  []*gqlclient.TestStepAttributes{{Name: &step, Description: &step, Status: &status}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create test: %v", err)
+	}
+	if len(test.Steps) != 1 || test.Steps[0].Name != step {
+		t.Fatalf("expected the created test to have step %s, got %+v", step, test.Steps)
+	}
+	if srv.Repos[test.Id] != "repo" {
+		t.Errorf("expected test to be created in repo, got %s", srv.Repos[test.Id])
+	}
+
+	stepId := test.Steps[0].Id
+	succeeded := gqlclient.TestStatusSucceeded
+	test, err = client.UpdateTest(test.Id, gqlclient.TestAttributes{
+		Status: &succeeded,
+		Steps:  []*gqlclient.TestStepAttributes{{ID: &stepId, Status: &succeeded}},
+	})
+	if err != nil {
+		t.Fatalf("failed to update test: %v", err)
+	}
+	if test.Status != plural.StatusSucceeded || test.Steps[0].Status != plural.StatusSucceeded {
+		t.Errorf("expected the test and its step to have succeeded, got %+v", test)
+	}
+
+	if err := client.PublishLogs(stepId, "hello"); err != nil {
+		t.Fatalf("failed to publish logs: %v", err)
+	}
+	if logs := srv.Logs[stepId]; len(logs) != 1 || logs[0] != "hello" {
+		t.Errorf("expected published logs, got %v", logs)
+	}
+
+	logFile := filepath.Join(t.TempDir(), "first.log")
+	if err := os.WriteFile(logFile, []byte("line one\nline two\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.UpdateStep(stepId, logFile); err != nil {
+		t.Fatalf("failed to upload logs: %v", err)
+	}
+	if srv.Uploads[stepId] != "line one\nline two\n" {
+		t.Errorf("expected the log file to be uploaded, got %q", srv.Uploads[stepId])
+	}
+
+	srv.Fail(plural.MethodPublishLogs, errors.New("boom"))
+	if err := client.PublishLogs(stepId, "again"); err == nil {
+		t.Errorf("expected injected errors to come back as graphql errors")
+	}
+}
+
+func TestServerUnauthorized(t *testing.T) {
+	srv := NewServer("token")
+	defer srv.Close()
+
+	retries := uint64(0)
+	conf := srv.Config()
+	conf.Token = "wrong"
+	conf.MaxRetries = &retries
+	if err := plural.NewClient(conf).PublishLogs("step", "hello"); err == nil {
+		t.Errorf("expected requests with the wrong token to fail")
+	}
+}
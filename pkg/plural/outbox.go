@@ -0,0 +1,447 @@
+package plural
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pluralsh/gqlclient"
+	"github.com/prometheus/client_golang/prometheus"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	outboxReplayInterval = 15 * time.Second
+	outboxLockStripes    = 32
+
+	// how long a step is remembered as belonging to its test, well past how long any test runs for
+	outboxStepRetention = 7 * 24 * time.Hour
+
+	outboxStepsFile = "steps.json"
+)
+
+var outboxLog = ctrl.Log.WithName("plural-outbox")
+
+// Operation is a call to plural waiting in the outbox
+type Operation struct {
+	Seq     uint64                    `json:"seq"`
+	Method  string                    `json:"method"`
+	TestId  string                    `json:"testId,omitempty"`
+	Id      string                    `json:"id"`
+	Test    *gqlclient.TestAttributes `json:"test,omitempty"`
+	Logs    string                    `json:"logs,omitempty"`
+	File    string                    `json:"file,omitempty"`
+	Created time.Time                 `json:"created"`
+}
+
+// Outbox wraps an Api so status and log updates plural couldn't take are persisted to a directory, usually on a
+// persistent volume, and replayed in order once it's reachable again.  Operations are queued per test, steps
+// being mapped to their test from the updates passing through, and that mapping is persisted too so ordering
+// holds across restarts.  Test creation is never queued since the caller needs the ids plural assigns.
+type Outbox struct {
+	Api
+
+	mu     sync.Mutex
+	dir    string
+	seq    uint64
+	queues map[string][]*Operation
+	steps  map[string]*stepRef
+
+	// serializes calls for the same test, so nothing can jump ahead of an operation being queued or replayed
+	locks [outboxLockStripes]sync.Mutex
+}
+
+type stepRef struct {
+	Test string    `json:"test"`
+	Seen time.Time `json:"seen"`
+}
+
+var _ Api = &Outbox{}
+
+// NewOutbox creates an outbox persisting to dir, picking up any operations left over from a previous run
+func NewOutbox(api Api, dir string) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	box := &Outbox{
+		Api:    api,
+		dir:    dir,
+		queues: map[string][]*Operation{},
+		steps:  map[string]*stepRef{},
+	}
+	return box, box.load()
+}
+
+func (box *Outbox) CreateTest(repo string, test gqlclient.TestAttributes) (*Test, error) {
+	res, err := box.Api.CreateTest(repo, test)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(res.Steps))
+	for _, step := range res.Steps {
+		ids = append(ids, step.Id)
+	}
+	box.mapSteps(res.Id, ids)
+	return res, nil
+}
+
+// UpdateTest returns a nil test if the update had to be queued
+func (box *Outbox) UpdateTest(id string, test gqlclient.TestAttributes) (*Test, error) {
+	ids := make([]string, 0, len(test.Steps))
+	for _, step := range test.Steps {
+		if step != nil && step.ID != nil {
+			ids = append(ids, *step.ID)
+		}
+	}
+	box.mapSteps(id, ids)
+
+	var res *Test
+	err := box.submit(&Operation{Method: MethodUpdateTest, TestId: id, Id: id, Test: &test}, func() (err error) {
+		res, err = box.Api.UpdateTest(id, test)
+		return
+	})
+	return res, err
+}
+
+func (box *Outbox) PublishLogs(stepId, logs string) error {
+	return box.submit(&Operation{Method: MethodPublishLogs, TestId: box.testId(stepId), Id: stepId, Logs: logs}, func() error {
+		return box.Api.PublishLogs(stepId, logs)
+	})
+}
+
+func (box *Outbox) UpdateStep(id string, logFile string) error {
+	return box.submit(&Operation{Method: MethodUpdateStep, TestId: box.testId(id), Id: id, File: logFile}, func() error {
+		return box.Api.UpdateStep(id, logFile)
+	})
+}
+
+// Start replays queued operations until the context is cancelled, so the outbox can be run by a manager
+func (box *Outbox) Start(ctx context.Context) error {
+	ticker := time.NewTicker(outboxReplayInterval)
+	defer ticker.Stop()
+	for {
+		box.replay()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Depth is the number of operations waiting in the outbox
+func (box *Outbox) Depth() int {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	depth := 0
+	for _, queue := range box.queues {
+		depth += len(queue)
+	}
+	return depth
+}
+
+// OldestAge is how long the oldest operation in the outbox has been waiting
+func (box *Outbox) OldestAge() time.Duration {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	var oldest time.Time
+	for _, queue := range box.queues {
+		if len(queue) > 0 && (oldest.IsZero() || queue[0].Created.Before(oldest)) {
+			oldest = queue[0].Created
+		}
+	}
+
+	if oldest.IsZero() {
+		return 0
+	}
+	return time.Since(oldest)
+}
+
+// Collectors exposes the outbox's queue depth and age as prometheus metrics
+func (box *Outbox) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "plural_outbox_depth",
+			Help: "Number of plural api operations waiting to be replayed",
+		}, func() float64 { return float64(box.Depth()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "plural_outbox_oldest_age_seconds",
+			Help: "Age of the oldest plural api operation waiting to be replayed",
+		}, func() float64 { return box.OldestAge().Seconds() }),
+	}
+}
+
+// submit calls plural straight away if nothing is queued ahead of the operation, and otherwise, or if the call
+// fails for a reason that may go away, persists it to be replayed later
+func (box *Outbox) submit(op *Operation, call func() error) error {
+	lock := box.lock(op.TestId)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if !box.pending(op.TestId) {
+		err := call()
+		if err == nil || !retryable(err) {
+			return err
+		}
+		outboxLog.Error(err, "queueing plural operation", "method", op.Method, "id", op.Id)
+	}
+
+	return box.enqueue(op)
+}
+
+func (box *Outbox) enqueue(op *Operation) error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+
+	queue := box.queues[op.TestId]
+	// only the latest state of a test matters, so back to back updates are collapsed into one
+	if n := len(queue); n > 0 && op.Method == MethodUpdateTest && queue[n-1].Method == MethodUpdateTest {
+		queue[n-1].Test = op.Test
+		return box.persist(queue[n-1])
+	}
+
+	box.seq++
+	op.Seq = box.seq
+	op.Created = time.Now()
+	if op.File != "" {
+		// the caller removes its log file once this returns, so keep a copy of our own
+		file, err := box.copyFile(op.File, op.Seq)
+		if err != nil {
+			return err
+		}
+		op.File = file
+	}
+
+	if err := box.persist(op); err != nil {
+		return err
+	}
+
+	box.queues[op.TestId] = append(queue, op)
+	return nil
+}
+
+// replay works through each test's queue in order, stopping at the first operation that still fails
+func (box *Outbox) replay() {
+	box.mu.Lock()
+	keys := make([]string, 0, len(box.queues))
+	for key := range box.queues {
+		keys = append(keys, key)
+	}
+	box.mu.Unlock()
+
+	for _, key := range keys {
+		box.replayQueue(key)
+	}
+}
+
+func (box *Outbox) replayQueue(key string) {
+	lock := box.lock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	for {
+		op := box.head(key)
+		if op == nil {
+			return
+		}
+
+		err := box.call(op)
+		if err != nil && retryable(err) {
+			outboxLog.Error(err, "failed to replay plural operation", "method", op.Method, "id", op.Id)
+			return
+		}
+
+		if err != nil {
+			outboxLog.Error(err, "dropping plural operation", "method", op.Method, "id", op.Id)
+		}
+		box.pop(key, op)
+	}
+}
+
+func (box *Outbox) call(op *Operation) error {
+	switch op.Method {
+	case MethodUpdateTest:
+		_, err := box.Api.UpdateTest(op.Id, *op.Test)
+		return err
+	case MethodPublishLogs:
+		return box.Api.PublishLogs(op.Id, op.Logs)
+	case MethodUpdateStep:
+		return box.Api.UpdateStep(op.Id, op.File)
+	}
+
+	return fmt.Errorf("unknown operation %s", op.Method)
+}
+
+func (box *Outbox) pending(key string) bool {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	return len(box.queues[key]) > 0
+}
+
+func (box *Outbox) head(key string) *Operation {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if queue := box.queues[key]; len(queue) > 0 {
+		return queue[0]
+	}
+	return nil
+}
+
+func (box *Outbox) pop(key string, op *Operation) {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	queue := box.queues[key]
+	if len(queue) > 0 && queue[0] == op {
+		queue = queue[1:]
+	}
+	if len(queue) == 0 {
+		delete(box.queues, key)
+	} else {
+		box.queues[key] = queue
+	}
+
+	os.Remove(box.opPath(op.Seq))
+	if op.File != "" {
+		os.Remove(op.File)
+	}
+}
+
+func (box *Outbox) lock(key string) *sync.Mutex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return &box.locks[h.Sum32()%outboxLockStripes]
+}
+
+func (box *Outbox) testId(stepId string) string {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	if ref, ok := box.steps[stepId]; ok {
+		return ref.Test
+	}
+	return stepId
+}
+
+// mapSteps records the test the steps belong to, persisting the mapping if any of them are new
+func (box *Outbox) mapSteps(test string, ids []string) {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	changed := false
+	for _, id := range ids {
+		if ref, ok := box.steps[id]; !ok || ref.Test != test {
+			box.steps[id] = &stepRef{Test: test, Seen: time.Now()}
+			changed = true
+		}
+	}
+
+	if !changed {
+		return
+	}
+
+	for id, ref := range box.steps {
+		if time.Since(ref.Seen) > outboxStepRetention {
+			delete(box.steps, id)
+		}
+	}
+	if err := box.writeFile(outboxStepsFile, box.steps); err != nil {
+		outboxLog.Error(err, "failed to persist plural step mapping")
+	}
+}
+
+func (box *Outbox) opPath(seq uint64) string {
+	return filepath.Join(box.dir, fmt.Sprintf("%020d.json", seq))
+}
+
+func (box *Outbox) persist(op *Operation) error {
+	return box.writeFile(filepath.Base(box.opPath(op.Seq)), op)
+}
+
+// writeFile atomically replaces a file in the outbox directory with the json encoding of v
+func (box *Outbox) writeFile(name string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(box.dir, name)
+	if err := os.WriteFile(path+".tmp", body, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (box *Outbox) copyFile(src string, seq uint64) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	// keep the original name since it's what the upload is named after
+	dst := filepath.Join(box.dir, fmt.Sprintf("%020d-%s", seq, filepath.Base(src)))
+	out, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+func (box *Outbox) load() error {
+	entries, err := os.ReadDir(box.dir)
+	if err != nil {
+		return err
+	}
+
+	if body, err := os.ReadFile(filepath.Join(box.dir, outboxStepsFile)); err == nil {
+		if err := json.Unmarshal(body, &box.steps); err != nil {
+			outboxLog.Error(err, "ignoring corrupt plural step mapping")
+		}
+		if box.steps == nil {
+			box.steps = map[string]*stepRef{}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	ops := make([]*Operation, 0)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") || entry.Name() == outboxStepsFile {
+			continue
+		}
+
+		body, err := os.ReadFile(filepath.Join(box.dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+
+		var op Operation
+		if err := json.Unmarshal(body, &op); err != nil {
+			outboxLog.Error(err, "skipping corrupt outbox entry", "file", entry.Name())
+			continue
+		}
+		ops = append(ops, &op)
+	}
+
+	sort.Slice(ops, func(i, j int) bool { return ops[i].Seq < ops[j].Seq })
+	for _, op := range ops {
+		box.queues[op.TestId] = append(box.queues[op.TestId], op)
+		if op.Seq > box.seq {
+			box.seq = op.Seq
+		}
+	}
+	return nil
+}
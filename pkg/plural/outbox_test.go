@@ -0,0 +1,219 @@
+package plural
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/pluralsh/gqlclient"
+)
+
+var errUnreachable = &url.Error{Op: "Post", URL: "https://app.plural.sh/gql", Err: errors.New("connection refused")}
+
+// stubApi records the calls that make it through to plural, failing them all while down
+type stubApi struct {
+	mu    sync.Mutex
+	down  bool
+	err   error
+	calls []string
+}
+
+func (api *stubApi) call(method, id string) error {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if api.down {
+		return errUnreachable
+	}
+	if api.err != nil {
+		return api.err
+	}
+	api.calls = append(api.calls, fmt.Sprintf("%s %s", method, id))
+	return nil
+}
+
+func (api *stubApi) setDown(down bool) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.down = down
+}
+
+func (api *stubApi) recorded() []string {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return append([]string{}, api.calls...)
+}
+
+func (api *stubApi) CreateTest(repo string, test gqlclient.TestAttributes) (*Test, error) {
+	if err := api.call(MethodCreateTest, repo); err != nil {
+		return nil, err
+	}
+	return &Test{Id: "test", Steps: []*TestStep{{Id: "step"}}}, nil
+}
+
+func (api *stubApi) UpdateTest(id string, test gqlclient.TestAttributes) (*Test, error) {
+	return &Test{Id: id}, api.call(MethodUpdateTest, id)
+}
+
+func (api *stubApi) PublishLogs(stepId, logs string) error {
+	return api.call(MethodPublishLogs, stepId+" "+logs)
+}
+
+func (api *stubApi) UpdateStep(id string, logFile string) error {
+	contents, err := os.ReadFile(logFile)
+	if err != nil {
+		return err
+	}
+	return api.call(MethodUpdateStep, id+" "+string(contents))
+}
+
+func testOutbox(t *testing.T, api Api, dir string) *Outbox {
+	box, err := NewOutbox(api, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return box
+}
+
+func TestOutboxReplaysInOrder(t *testing.T) {
+	api := &stubApi{}
+	box := testOutbox(t, api, t.TempDir())
+	if _, err := box.CreateTest("repo", gqlclient.TestAttributes{}); err != nil {
+		t.Fatal(err)
+	}
+
+	api.setDown(true)
+	status := gqlclient.TestStatusRunning
+	if _, err := box.UpdateTest("test", gqlclient.TestAttributes{Status: &status}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := box.UpdateTest("test", gqlclient.TestAttributes{Status: &status}); err != nil {
+		t.Fatal(err)
+	}
+	if err := box.PublishLogs("step", "first"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the caller removes its log file as soon as the upload is queued
+	logFile := filepath.Join(t.TempDir(), "step.log")
+	if err := os.WriteFile(logFile, []byte("log file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := box.UpdateStep("step", logFile); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(logFile)
+
+	if depth := box.Depth(); depth != 3 {
+		t.Fatalf("expected back to back test updates to be collapsed, leaving 3 queued operations, got %d", depth)
+	}
+
+	// once plural is back, nothing may jump ahead of what's already queued for the test
+	api.setDown(false)
+	if err := box.PublishLogs("step", "second"); err != nil {
+		t.Fatal(err)
+	}
+	if calls := api.recorded(); len(calls) != 1 {
+		t.Fatalf("expected new operations to queue behind pending ones, got %v", calls)
+	}
+
+	box.replay()
+	expected := []string{
+		"CreateTest repo",
+		"UpdateTest test",
+		"PublishLogs step first",
+		"UpdateStep step log file",
+		"PublishLogs step second",
+	}
+	if calls := api.recorded(); !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected calls %v, got %v", expected, calls)
+	}
+	if depth := box.Depth(); depth != 0 {
+		t.Errorf("expected the outbox to be drained, got %d operations", depth)
+	}
+}
+
+func TestOutboxSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	api := &stubApi{}
+	box := testOutbox(t, api, dir)
+	if _, err := box.CreateTest("repo", gqlclient.TestAttributes{}); err != nil {
+		t.Fatal(err)
+	}
+
+	api.setDown(true)
+	status := gqlclient.TestStatusSucceeded
+	if _, err := box.UpdateTest("test", gqlclient.TestAttributes{Status: &status}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new controller picks up the queue and still knows which test the step belongs to
+	api.setDown(false)
+	restarted := testOutbox(t, api, dir)
+	if depth := restarted.Depth(); depth != 1 {
+		t.Fatalf("expected the queued update to be loaded, got %d operations", depth)
+	}
+	if err := restarted.PublishLogs("step", "after restart"); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted.replay()
+	expected := []string{"CreateTest repo", "UpdateTest test", "PublishLogs step after restart"}
+	if calls := api.recorded(); !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected calls %v, got %v", expected, calls)
+	}
+}
+
+func TestOutboxReturnsPermanentErrors(t *testing.T) {
+	api := &stubApi{err: errors.New("invalid step")}
+	box := testOutbox(t, api, t.TempDir())
+	if err := box.PublishLogs("step", "line"); err == nil {
+		t.Error("expected errors plural returns to be passed back")
+	}
+	if depth := box.Depth(); depth != 0 {
+		t.Errorf("expected permanent failures not to be queued, got %d operations", depth)
+	}
+}
+
+func TestOutboxConcurrentSubmits(t *testing.T) {
+	api := &stubApi{}
+	box := testOutbox(t, api, t.TempDir())
+	if _, err := box.CreateTest("repo", gqlclient.TestAttributes{}); err != nil {
+		t.Fatal(err)
+	}
+
+	api.setDown(true)
+	if err := box.PublishLogs("step", "0"); err != nil {
+		t.Fatal(err)
+	}
+	api.setDown(false)
+
+	// replays racing direct calls must still deliver everything exactly once, in order per caller
+	var wg sync.WaitGroup
+	for i := 1; i <= 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%5 == 0 {
+				box.replay()
+			}
+			if err := box.PublishLogs("step", fmt.Sprint(i)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	box.replay()
+
+	calls := api.recorded()
+	if len(calls) != 22 {
+		t.Fatalf("expected every publish to go through once, got %d calls", len(calls))
+	}
+	if calls[1] != "PublishLogs step 0" {
+		t.Errorf("expected the queued publish to go first, got %s", calls[1])
+	}
+}
@@ -0,0 +1,84 @@
+package plural
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	gqlgenc "github.com/Yamashou/gqlgenc/client"
+	"github.com/sethvargo/go-retry"
+)
+
+const (
+	defaultRateLimit  = 5
+	defaultBurst      = 10
+	defaultMaxRetries = 5
+	defaultRetryBase  = 500 * time.Millisecond
+	defaultMaxBackoff = 10 * time.Second
+)
+
+// do runs a single api call under the client's rate limit, retrying transient failures with exponential backoff
+func (client *Client) do(call func(ctx context.Context) error) error {
+	return retry.Do(client.ctx, client.backoff(), func(ctx context.Context) error {
+		if err := client.limiter.Wait(ctx); err != nil {
+			return err
+		}
+
+		if err := call(ctx); err != nil {
+			if retryable(err) {
+				return retry.RetryableError(err)
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+func (client *Client) backoff() retry.Backoff {
+	conf := client.config
+	base, maxRetries := defaultRetryBase, uint64(defaultMaxRetries)
+	if conf.RetryBase > 0 {
+		base = conf.RetryBase
+	}
+	if conf.MaxRetries != nil {
+		maxRetries = *conf.MaxRetries
+	}
+
+	backoff := retry.NewExponential(base)
+	backoff = retry.WithCappedDuration(defaultMaxBackoff, backoff)
+	backoff = retry.WithJitterPercent(10, backoff)
+	return retry.WithMaxRetries(maxRetries, backoff)
+}
+
+// doOnce runs a single api call under the client's rate limit without retrying it, for calls that aren't safe to
+// repeat if a failed attempt may still have gone through
+func (client *Client) doOnce(call func(ctx context.Context) error) error {
+	if err := client.limiter.Wait(client.ctx); err != nil {
+		return err
+	}
+	return call(client.ctx)
+}
+
+// retryable checks whether a failed call is worth trying again.  Graphql errors are returned by plural itself
+// and won't go away on retry, while connection failures, throttling and server errors usually will.  Anything
+// else, like a log file that can't be read or a response that can't be decoded, fails the same way every time.
+func retryable(err error) bool {
+	var resp *gqlgenc.ErrorResponse
+	if errors.As(err, &resp) {
+		if resp.NetworkError == nil {
+			return false
+		}
+
+		code := resp.NetworkError.Code
+		return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+	}
+
+	// only errors from the transport itself, since plenty of local failures like a missing file implement net.Error
+	var urlErr *url.Error
+	var opErr *net.OpError
+	return errors.As(err, &urlErr) || errors.As(err, &opErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
@@ -0,0 +1,106 @@
+package plural
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gqlgenc "github.com/Yamashou/gqlgenc/client"
+	"github.com/pluralsh/gqlclient"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+func TestRetryable(t *testing.T) {
+	_, openErr := os.Open("/does/not/exist")
+	cases := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"graphql errors", &gqlgenc.ErrorResponse{GqlErrors: &gqlerror.List{{Message: "invalid"}}}, false},
+		{"throttling", &gqlgenc.ErrorResponse{NetworkError: &gqlgenc.HTTPError{Code: http.StatusTooManyRequests}}, true},
+		{"server errors", &gqlgenc.ErrorResponse{NetworkError: &gqlgenc.HTTPError{Code: http.StatusBadGateway}}, true},
+		{"client errors", &gqlgenc.ErrorResponse{NetworkError: &gqlgenc.HTTPError{Code: http.StatusBadRequest}}, false},
+		{"connection failures", fmt.Errorf("request failed: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}), true},
+		{"timeouts", &url.Error{Op: "Post", URL: "https://app.plural.sh/gql", Err: context.DeadlineExceeded}, true},
+		{"cut off responses", fmt.Errorf("failed to read response body: %w", io.ErrUnexpectedEOF), true},
+		{"missing upload files", openErr, false},
+		{"undecodable responses", errors.New("failed to decode data"), false},
+	}
+
+	for _, c := range cases {
+		if res := retryable(c.err); res != c.expected {
+			t.Errorf("%s: expected retryable to be %v", c.name, c.expected)
+		}
+	}
+}
+
+func TestDo(t *testing.T) {
+	retries := uint64(3)
+	client := NewClient(&Config{MaxRetries: &retries, RetryBase: time.Millisecond})
+
+	calls := 0
+	err := client.do(func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("expected transient failures to be retried until success, got %d calls and %v", calls, err)
+	}
+
+	calls = 0
+	err = client.do(func(ctx context.Context) error {
+		calls++
+		return errors.New("permanent")
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("expected permanent failures not to be retried, got %d calls", calls)
+	}
+
+	calls = 0
+	err = client.do(func(ctx context.Context) error {
+		calls++
+		return &gqlgenc.ErrorResponse{NetworkError: &gqlgenc.HTTPError{Code: http.StatusServiceUnavailable}}
+	})
+	if err == nil || calls != 4 {
+		t.Errorf("expected retries to give up after the limit, got %d calls", calls)
+	}
+}
+
+func TestCreateTestNotRetried(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	retries := uint64(3)
+	client := NewClient(&Config{Endpoint: srv.URL, MaxRetries: &retries, RetryBase: time.Millisecond})
+	if _, err := client.CreateTest("repo", gqlclient.TestAttributes{}); err == nil {
+		t.Fatal("expected creating the test to fail")
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("expected a single create request, got %d", n)
+	}
+
+	atomic.StoreInt32(&requests, 0)
+	if err := client.PublishLogs("step", "hello"); err == nil {
+		t.Fatal("expected publishing logs to fail")
+	}
+	if n := atomic.LoadInt32(&requests); n != 4 {
+		t.Errorf("expected publishing logs to be retried, got %d requests", n)
+	}
+}
@@ -1,6 +1,7 @@
 package plural
 
 import (
+	"context"
 	"fmt"
 	"os"
 
@@ -15,8 +16,29 @@ const (
 	StatusRunning   Status = "RUNNING"
 	StatusSucceeded Status = "SUCCEEDED"
 	StatusFailed    Status = "FAILED"
+
+	// StatusCancelled is only tracked by the harness, plural itself sees cancelled tests as failed
+	StatusCancelled Status = "CANCELLED"
+)
+
+// the names of the Api's methods, as recorded by the outbox and fakes
+const (
+	MethodCreateTest  = "CreateTest"
+	MethodUpdateTest  = "UpdateTest"
+	MethodPublishLogs = "PublishLogs"
+	MethodUpdateStep  = "UpdateStep"
 )
 
+// Api covers the plural test apis the harness relies on, so they can be swapped out for a fake in tests
+type Api interface {
+	CreateTest(repo string, test gqlclient.TestAttributes) (*Test, error)
+	UpdateTest(id string, test gqlclient.TestAttributes) (*Test, error)
+	PublishLogs(stepId, logs string) error
+	UpdateStep(id string, logFile string) error
+}
+
+var _ Api = &Client{}
+
 type TestStep struct {
 	Id          string `json:"id,omitempty"`
 	Name        string
@@ -33,8 +55,14 @@ type Test struct {
 	Steps      []*TestStep
 }
 
+// CreateTest isn't retried, since a request that timed out may still have created the test and retrying it straight
+// away would register a duplicate
 func (client *Client) CreateTest(repo string, test gqlclient.TestAttributes) (*Test, error) {
-	resp, err := client.pluralClient.CreateTest(client.ctx, repo, test)
+	var resp *gqlclient.CreateTest
+	err := client.doOnce(func(ctx context.Context) (err error) {
+		resp, err = client.pluralClient.CreateTest(ctx, repo, test)
+		return
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -43,7 +71,11 @@ func (client *Client) CreateTest(repo string, test gqlclient.TestAttributes) (*T
 }
 
 func (client *Client) UpdateTest(id string, test gqlclient.TestAttributes) (*Test, error) {
-	resp, err := client.pluralClient.UpdateTest(client.ctx, id, test)
+	var resp *gqlclient.UpdateTest
+	err := client.do(func(ctx context.Context) (err error) {
+		resp, err = client.pluralClient.UpdateTest(ctx, id, test)
+		return
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -52,31 +84,30 @@ func (client *Client) UpdateTest(id string, test gqlclient.TestAttributes) (*Tes
 }
 
 func (client *Client) PublishLogs(stepId, logs string) error {
-	_, err := client.pluralClient.PublishLogs(client.ctx, stepId, logs)
-	if err != nil {
+	return client.do(func(ctx context.Context) error {
+		_, err := client.pluralClient.PublishLogs(ctx, stepId, logs)
 		return err
-	}
-	return nil
+	})
 }
 
 func (client *Client) UpdateStep(id string, logFile string) error {
-	f, err := os.Open(logFile)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	_, err = client.pluralClient.UpdateStep(client.ctx, id, "logs", gqlclient.WithFiles([]gqlclient.Upload{
-		{
-			Field: "logs",
-			Name:  logFile,
-			R:     f,
-		},
-	}))
-	if err != nil {
+	return client.do(func(ctx context.Context) error {
+		// the upload consumes the file, so every attempt needs its own handle
+		f, err := os.Open(logFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = client.pluralClient.UpdateStep(ctx, id, "logs", gqlclient.WithFiles([]gqlclient.Upload{
+			{
+				Field: "logs",
+				Name:  logFile,
+				R:     f,
+			},
+		}))
 		return err
-	}
-	return nil
+	})
 }
 
 func convertTest(testFragment *gqlclient.TestFragment) (*Test, error) {
@@ -1,55 +1,320 @@
 package plural
 
 import (
+	"context"
 	"fmt"
-	phx "github.com/Douvi/gophoenix"
-	"net/http"
 	"net/url"
 	"sync"
+	"time"
+
+	phx "github.com/Douvi/gophoenix"
+	"github.com/gorilla/websocket"
+	"github.com/sethvargo/go-retry"
+	ctrl "sigs.k8s.io/controller-runtime"
 )
 
+const (
+	heartbeatInterval   = 30 * time.Second
+	socketWriteTimeout  = 10 * time.Second
+	socketReadLimit     = 1 << 20
+	reconnectBase       = time.Second
+	maxReconnectBackoff = time.Minute
+)
+
+var socketLog = ctrl.Log.WithName("plural-socket")
+
+// Socket is a phoenix socket connection to plural.  Whenever the connection drops it reconnects with backoff and
+// rejoins every channel joined through it.  Only gophoenix's wire types are used, since its transport can't
+// recover from a dropped connection.
 type Socket struct {
-	mu        sync.Mutex
-	Client    *phx.Client
-	Config    *Config
-	Connected bool
+	mu           sync.Mutex
+	writeMu      sync.Mutex
+	Config       *Config
+	Connected    bool
+	conn         *websocket.Conn
+	ref          int64
+	channels     map[string]*Channel
+	ctx          context.Context
+	cancel       context.CancelFunc
+	reconnecting bool
 }
 
-func (s *Socket) NotifyDisconnect() {
-	s.Connected = false
+// Channel is a topic joined on a Socket
+type Channel struct {
+	socket   *Socket
+	receiver phx.ChannelReceiver
+	Topic    string
+	joinRef  int64
+	joined   bool
 }
 
-func (s *Socket) NotifyConnect() {
-	s.Connected = true
+func NewSocket(config *Config) *Socket {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Socket{
+		Config:   config,
+		channels: map[string]*Channel{},
+		ctx:      ctx,
+		cancel:   cancel,
+	}
 }
 
-func WebSocket(config *Config) (socket Socket) {
-	socket.Config = config
-	socket.Client = phx.NewWebsocketClient(&socket)
-	return
+func (socket *Socket) NotifyDisconnect() {
+	socket.mu.Lock()
+	defer socket.mu.Unlock()
+	socket.Connected = false
+	socket.conn = nil
+	for _, ch := range socket.channels {
+		ch.joined = false
+	}
+
+	if socket.reconnecting || socket.ctx.Err() != nil {
+		return
+	}
+	socket.reconnecting = true
+	go socket.reconnect()
 }
 
-func (socket *Socket) Connect() error {
+func (socket *Socket) NotifyConnect() {
+	socket.mu.Lock()
+	defer socket.mu.Unlock()
+	socket.Connected = true
+}
+
+// Start connects in the background, retrying until it succeeds or the socket is closed
+func (socket *Socket) Start() {
 	socket.mu.Lock()
 	defer socket.mu.Unlock()
-	if socket.Connected {
+	if socket.Connected || socket.reconnecting || socket.ctx.Err() != nil {
+		return
+	}
+	socket.reconnecting = true
+	go socket.reconnect()
+}
+
+// Connect dials plural and rejoins every channel.  The lock is only taken to swap in the new connection, so
+// publishing and joins carry on, falling back where they need to, while a dial is in flight.
+func (socket *Socket) Connect() error {
+	socket.mu.Lock()
+	connected, conf := socket.Connected, socket.Config
+	socket.mu.Unlock()
+	if connected {
 		return nil
 	}
 
-	conf := socket.Config
-	url, err := url.Parse(fmt.Sprintf("wss://%s/socket/websocket?token=%s", conf.PluralEndpoint(), conf.Token))
+	url, err := url.Parse(fmt.Sprintf("%s/socket/websocket?token=%s", conf.SocketUrl(), url.QueryEscape(conf.Token)))
 	if err != nil {
 		return err
 	}
 
-	if err := socket.Client.Connect(*url, http.Header{}); err != nil {
+	conn, _, err := websocket.DefaultDialer.DialContext(socket.ctx, url.String(), nil)
+	if err != nil {
 		return err
 	}
+	conn.SetReadLimit(socketReadLimit)
+
+	socket.mu.Lock()
+	if socket.Connected || socket.ctx.Err() != nil {
+		socket.mu.Unlock()
+		conn.Close()
+		return socket.ctx.Err()
+	}
 
+	socket.conn = conn
 	socket.Connected = true
+	joins := make([]*phx.Message, 0, len(socket.channels))
+	for _, ch := range socket.channels {
+		joins = append(joins, socket.joinMessage(ch))
+	}
+	socket.mu.Unlock()
+
+	done := make(chan struct{})
+	go socket.listen(conn, done)
+	go socket.heartbeat(conn, done)
+
+	for _, msg := range joins {
+		if err := socket.write(conn, msg); err != nil {
+			// the listener picks the closed connection up as a disconnect and reconnects
+			conn.Close()
+			return err
+		}
+	}
 	return nil
 }
 
-func (socket *Socket) Join(callback phx.ChannelReceiver, topic string) (*phx.Channel, error) {
-	return socket.Client.Join(callback, topic, map[string]string{})
+// Join subscribes to a topic.  The channel is joined straight away if connected and otherwise on the next
+// successful connection, as it is after every reconnect.
+func (socket *Socket) Join(callback phx.ChannelReceiver, topic string) (*Channel, error) {
+	socket.mu.Lock()
+	ch := &Channel{socket: socket, receiver: callback, Topic: topic}
+	socket.channels[topic] = ch
+	if !socket.Connected {
+		socket.mu.Unlock()
+		return ch, nil
+	}
+	conn, msg := socket.conn, socket.joinMessage(ch)
+	socket.mu.Unlock()
+
+	return ch, socket.write(conn, msg)
+}
+
+// Close drops the connection for good
+func (socket *Socket) Close() {
+	socket.cancel()
+	socket.mu.Lock()
+	defer socket.mu.Unlock()
+	if socket.conn != nil {
+		socket.conn.Close()
+	}
+}
+
+// Joined checks whether the server has accepted the channel's join and the connection is still up
+func (ch *Channel) Joined() bool {
+	ch.socket.mu.Lock()
+	defer ch.socket.mu.Unlock()
+	return ch.joined
+}
+
+// Push sends an event on the channel, failing if it isn't currently joined
+func (ch *Channel) Push(event string, payload interface{}) error {
+	socket := ch.socket
+	socket.mu.Lock()
+	if !ch.joined {
+		socket.mu.Unlock()
+		return fmt.Errorf("channel %s is not joined", ch.Topic)
+	}
+	conn, ref := socket.conn, socket.nextRef()
+	socket.mu.Unlock()
+
+	return socket.write(conn, &phx.Message{Topic: ch.Topic, Event: event, Payload: payload, Ref: ref})
+}
+
+// Leave unsubscribes from the channel's topic
+func (ch *Channel) Leave() error {
+	socket := ch.socket
+	socket.mu.Lock()
+	delete(socket.channels, ch.Topic)
+	joined := ch.joined
+	ch.joined = false
+	conn, ref := socket.conn, socket.nextRef()
+	socket.mu.Unlock()
+
+	if !joined {
+		return nil
+	}
+	return socket.write(conn, &phx.Message{Topic: ch.Topic, Event: string(phx.LeaveEvent), Payload: map[string]string{}, Ref: ref})
+}
+
+func (socket *Socket) reconnect() {
+	backoff := retry.NewExponential(reconnectBase)
+	backoff = retry.WithCappedDuration(maxReconnectBackoff, backoff)
+	backoff = retry.WithJitterPercent(10, backoff)
+	_ = retry.Do(socket.ctx, backoff, func(ctx context.Context) error {
+		if err := socket.Connect(); err != nil {
+			socketLog.Error(err, "failed to connect to plural socket")
+			return retry.RetryableError(err)
+		}
+		return nil
+	})
+
+	socket.mu.Lock()
+	defer socket.mu.Unlock()
+	socket.reconnecting = false
+}
+
+// joinMessage builds a join for the channel, the lock needs to be held
+func (socket *Socket) joinMessage(ch *Channel) *phx.Message {
+	ch.joinRef = socket.nextRef()
+	return &phx.Message{Topic: ch.Topic, Event: string(phx.JoinEvent), Payload: map[string]string{}, Ref: ch.joinRef}
+}
+
+func (socket *Socket) nextRef() int64 {
+	socket.ref++
+	return socket.ref
+}
+
+func (socket *Socket) write(conn *websocket.Conn, msg *phx.Message) error {
+	if conn == nil {
+		return fmt.Errorf("socket is not connected")
+	}
+
+	socket.writeMu.Lock()
+	defer socket.writeMu.Unlock()
+	_ = conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+	return conn.WriteJSON(msg)
+}
+
+func (socket *Socket) listen(conn *websocket.Conn, done chan struct{}) {
+	defer close(done)
+	for {
+		var msg phx.Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			conn.Close()
+			socket.disconnected(conn)
+			return
+		}
+
+		socket.dispatch(&msg)
+	}
+}
+
+func (socket *Socket) heartbeat(conn *websocket.Conn, done chan struct{}) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			socket.mu.Lock()
+			ref := socket.nextRef()
+			socket.mu.Unlock()
+			// a failed write closes the connection, which the listener then picks up as a disconnect
+			if err := socket.write(conn, &phx.Message{Topic: "phoenix", Event: "heartbeat", Payload: map[string]string{}, Ref: ref}); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// disconnected ignores connections that have already been replaced
+func (socket *Socket) disconnected(conn *websocket.Conn) {
+	socket.mu.Lock()
+	current := socket.conn == conn
+	socket.mu.Unlock()
+	if current {
+		socket.NotifyDisconnect()
+	}
+}
+
+func (socket *Socket) dispatch(msg *phx.Message) {
+	socket.mu.Lock()
+	ch, ok := socket.channels[msg.Topic]
+	if !ok {
+		socket.mu.Unlock()
+		return
+	}
+
+	event := phx.Event(msg.Event)
+	switch {
+	case event == phx.ReplyEvent && msg.Ref == ch.joinRef:
+		payload, _ := msg.Payload.(map[string]interface{})
+		joined := payload != nil && payload["status"] == "ok"
+		ch.joined = joined
+		socket.mu.Unlock()
+		if joined {
+			ch.receiver.OnJoin(payload["response"])
+		} else {
+			ch.receiver.OnJoinError(msg.Payload)
+		}
+	case event == phx.ReplyEvent:
+		socket.mu.Unlock()
+	case event == phx.CloseEvent || event == phx.ErrorEvent:
+		ch.joined = false
+		socket.mu.Unlock()
+		ch.receiver.OnChannelClose(msg.Payload)
+	default:
+		socket.mu.Unlock()
+		ch.receiver.OnMessage(msg.Ref, msg.Event, msg.Payload)
+	}
 }
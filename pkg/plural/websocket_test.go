@@ -0,0 +1,115 @@
+package plural_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pluralsh/test-harness/pkg/plural"
+	"github.com/pluralsh/test-harness/pkg/plural/fake"
+)
+
+type receiver struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (r *receiver) OnJoin(payload interface{})         {}
+func (r *receiver) OnJoinError(payload interface{})    {}
+func (r *receiver) OnChannelClose(payload interface{}) {}
+
+func (r *receiver) OnMessage(ref int64, event string, payload interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, event)
+}
+
+func (r *receiver) received() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.messages)
+}
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestSocketReconnects(t *testing.T) {
+	srv := fake.NewServer("token")
+	defer srv.Close()
+
+	socket := plural.NewSocket(srv.Config())
+	defer socket.Close()
+	socket.Start()
+
+	recv := &receiver{}
+	ch, err := socket.Join(recv, "tests:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the channel to be joined", ch.Joined)
+
+	if err := ch.Push("log", map[string]string{"line": "before"}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the first push", func() bool { return len(srv.Messages("tests:1")) == 1 })
+
+	// while the server turns sockets away the channel stays down and pushes fail, so callers can fall back
+	srv.RefuseSockets(true)
+	srv.DisconnectSockets()
+	eventually(t, "the channel to drop", func() bool { return !ch.Joined() })
+	if err := ch.Push("log", map[string]string{"line": "during"}); err == nil {
+		t.Error("expected pushes to fail while disconnected")
+	}
+
+	srv.RefuseSockets(false)
+	eventually(t, "the channel to be rejoined", ch.Joined)
+	if err := ch.Push("log", map[string]string{"line": "after"}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the push after reconnecting", func() bool { return len(srv.Messages("tests:1")) == 2 })
+
+	if err := srv.Broadcast("tests:1", "cancel", map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the broadcast", func() bool { return recv.received() == 1 })
+}
+
+func TestSocketCloseDuringDial(t *testing.T) {
+	srv := fake.NewServer("token")
+	defer srv.Close()
+	srv.RefuseSockets(true)
+
+	socket := plural.NewSocket(srv.Config())
+	socket.Start()
+	ch, err := socket.Join(&receiver{}, "tests:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// joins and pushes never wait on a connection attempt
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			ch.Joined()
+			_ = ch.Push("log", map[string]string{})
+			if _, err := socket.Join(&receiver{}, "tests:2"); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel operations blocked while the socket was reconnecting")
+	}
+	socket.Close()
+}